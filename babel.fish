@@ -3,7 +3,7 @@ function _babelfish_source -S
   if test "$argv[1]" = '-' || string match -q '*.fish' "$argv[1]" || test -z "$argv[1]"
     builtin source $argv
   else
-    babelfish < $argv[1] | builtin source
+    babelfish -source-path $argv[1] < $argv[1] | builtin source
   end
 end
 
@@ -11,10 +11,14 @@ import (
 )
 
 type Options struct {
-	Dump bool
+	Dump            bool
+	NoWordSplitting bool
+	Gettext         bool
+	BashVersion     string
+	SourcePath      string
 }
 
-func perform(name string, in io.Reader) error {
+func perform(o *Options, name string, in io.Reader) error {
 	out := os.Stdout
 	errOut := os.Stderr
 	p := syntax.NewParser(syntax.KeepComments(true), syntax.Variant(syntax.LangBash))
@@ -24,6 +28,10 @@ func perform(name string, in io.Reader) error {
 	}
 
 	t := translate.NewTranslator()
+	t.WordSplitting(!o.NoWordSplitting)
+	t.Gettext(o.Gettext)
+	t.BashVersion(o.BashVersion)
+	t.SourcePath(o.SourcePath)
 
 	loc := os.Args[0]
 	// If the file path is relative, make it absolute
@@ -35,6 +43,9 @@ func perform(name string, in io.Reader) error {
 	t.BabelfishLocation(loc)
 
 	err = t.File(output)
+	for _, w := range t.Warnings() {
+		fmt.Fprintf(errOut, "warning: %s\n", w)
+	}
 	if err, _ := err.(*translate.UnsupportedError); err != nil {
 		syntax.NewPrinter().Print(errOut, err.Node)
 		fmt.Fprintln(errOut)
@@ -50,6 +61,10 @@ func perform(name string, in io.Reader) error {
 func do() error {
 	var o Options
 	flag.BoolVar(&o.Dump, "dump", false, "Dump the AST")
+	flag.BoolVar(&o.NoWordSplitting, "no-word-splitting", false, "Don't split unquoted expansions on IFS, for scripts that quote properly")
+	flag.BoolVar(&o.Gettext, "gettext", false, "Translate $\"...\" strings with gettext")
+	flag.StringVar(&o.SourcePath, "source-path", "", "The path of the script, when the output is sourced right away like babel.fish does")
+	flag.StringVar(&o.BashVersion, "bash-version", translate.DefaultBashVersion, "The bash version that $BASH_VERSION reports")
 	flag.Parse()
 
 	f := os.Stdin
@@ -63,7 +78,7 @@ func do() error {
 		fmt.Fprintln(os.Stderr)
 		return nil
 	}
-	return perform(f.Name(), f)
+	return perform(&o, f.Name(), f)
 }
 
 func main() {
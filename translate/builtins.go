@@ -0,0 +1,1326 @@
+package translate
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// source translates a piece of bash code that's only known as a string, like the body of a trap
+func (t *Translator) source(n syntax.Node, src string) {
+	p := syntax.NewParser(syntax.KeepComments(true), syntax.Variant(syntax.LangBash))
+	f, err := p.Parse(strings.NewReader(src), "")
+	if err != nil {
+		unsupportedf(n, "can't parse %q: %v", src, err)
+	}
+	t.body(f.Stmts...)
+}
+
+// babelfish writes the command that runs babelfish with the same options as this translation
+func (t *Translator) babelfish() {
+	t.str(t.babelFishLocation)
+	if t.noWordSplitting {
+		t.str(" -no-word-splitting")
+	}
+	if t.gettext {
+		t.str(" -gettext")
+	}
+	if t.bashVersion != "" && t.bashVersion != DefaultBashVersion {
+		t.str(" -bash-version ")
+		t.escapedString(t.bashVersion)
+	}
+}
+
+// sourceWord returns the bash code contained in a word, if it can be known statically.
+// Bash expands the expansions inside of double quotes right away, so their values are saved
+// into generated global variables, which the code refers to instead.
+func (t *Translator) sourceWord(w *syntax.Word) (string, bool) {
+	var sb strings.Builder
+	for _, part := range w.Parts {
+		switch part := part.(type) {
+		case *syntax.Lit:
+			sb.WriteString(unescapeQuoted(part.Value, quoteNone))
+		case *syntax.SglQuoted:
+			if part.Dollar {
+				sb.WriteString(decodeANSIC(part.Value))
+				continue
+			}
+			sb.WriteString(part.Value)
+		case *syntax.DblQuoted:
+			for _, part := range part.Parts {
+				if lit, ok := part.(*syntax.Lit); ok {
+					sb.WriteString(unescapeQuoted(lit.Value, quoteDouble))
+					continue
+				}
+				name := t.uniqueName("__babelfish_trap")
+				t.printf("set -g %s ", name)
+				t.wordPart(part, true)
+				t.nl()
+				fmt.Fprintf(&sb, "${%s}", name)
+			}
+		default:
+			return "", false
+		}
+	}
+	return sb.String(), true
+}
+
+var signalNumbers = map[string]string{
+	"0":  "EXIT",
+	"1":  "HUP",
+	"2":  "INT",
+	"3":  "QUIT",
+	"6":  "ABRT",
+	"9":  "KILL",
+	"10": "USR1",
+	"12": "USR2",
+	"13": "PIPE",
+	"14": "ALRM",
+	"15": "TERM",
+}
+
+func signalName(s string) string {
+	if name, ok := signalNumbers[s]; ok {
+		return name
+	}
+	return strings.TrimPrefix(strings.ToUpper(s), "SIG")
+}
+
+// trapHandler is the name of the function that handles a trapped signal.
+// There's one per signal, so setting a trap again simply redefines the function.
+func trapHandler(signal string) string {
+	return "__babelfish_trap_" + signal
+}
+
+// setsReturnTrap returns whether a function body sets a RETURN trap
+func setsReturnTrap(n syntax.Node) bool {
+	found := false
+	syntax.Walk(n, func(n syntax.Node) bool {
+		switch n := n.(type) {
+		case *syntax.FuncDecl:
+			// Nested functions are handled on their own
+			return false
+		case *syntax.CallExpr:
+			if len(n.Args) == 0 {
+				break
+			}
+			if l, _ := lit(n.Args[0]); l != "trap" {
+				break
+			}
+			for _, a := range n.Args[1:] {
+				if sig, ok := lit(a); ok && signalName(sig) == "RETURN" {
+					found = true
+				}
+			}
+		}
+		return !found
+	})
+	return found
+}
+
+// trap translates the trap builtin into fish event handlers
+func (t *Translator) trap(c *syntax.CallExpr) {
+	args := c.Args[1:]
+	if len(args) > 0 {
+		if l, _ := lit(args[0]); l == "--" {
+			args = args[1:]
+		}
+	}
+
+	if len(args) == 0 {
+		t.str("functions (functions -an | string match '__babelfish_trap_*')")
+		return
+	}
+
+	action, ok := lit(args[0])
+	switch {
+	case ok && action == "-l":
+		t.str("kill -l")
+		return
+	case ok && action == "-p":
+		if len(args) == 1 {
+			t.str("functions (functions -an | string match '__babelfish_trap_*')")
+			return
+		}
+		t.str("functions")
+		for _, a := range args[1:] {
+			sig, ok := lit(a)
+			if !ok {
+				unsupported(a)
+			}
+			t.printf(" %s", trapHandler(signalName(sig)))
+		}
+		return
+	case ok && len(args) == 1:
+		// trap SIG resets the signal
+		action = "-"
+	default:
+		args = args[1:]
+	}
+
+	var body string
+	reset := ok && action == "-"
+	if !reset {
+		if body, ok = t.sourceWord(c.Args[len(c.Args)-len(args)-1]); !ok {
+			unsupportedf(c, "trap action can't be resolved statically")
+		}
+	}
+
+	for i, a := range args {
+		sig, ok := lit(a)
+		if !ok {
+			unsupported(a)
+		}
+		sig = signalName(sig)
+		if i > 0 {
+			t.nl()
+		}
+		handler := trapHandler(sig)
+		if reset {
+			t.printf("functions -e %s", handler)
+			continue
+		}
+
+		t.printf("function %s", handler)
+		switch sig {
+		case "EXIT":
+			t.str(" --on-process-exit $fish_pid")
+		case "ERR", "DEBUG":
+			t.warnf(c, "trap on %s only runs for commands typed interactively in fish", sig)
+			if sig == "ERR" {
+				t.str(" --on-event fish_postexec")
+			} else {
+				t.str(" --on-event fish_preexec")
+			}
+		case "RETURN":
+			// The function that set the trap runs it when it returns, see funcDecl
+			if !t.inFunction {
+				unsupportedf(c, "trap on RETURN outside of a function")
+			}
+		default:
+			t.printf(" --on-signal %s", sig)
+		}
+		if strings.TrimSpace(body) == "" {
+			// Empty action means the signal is ignored
+			t.nl()
+		} else {
+			t.indent()
+			if sig == "ERR" {
+				t.str("test $status -eq 0; and return")
+				t.nl()
+			}
+			t.functionScope(func() {
+				t.source(c, body)
+			})
+			t.outdent()
+		}
+		t.str("end")
+	}
+}
+
+// shift translates shift, which removes the first N positional parameters
+func (t *Translator) shift(c *syntax.CallExpr) {
+	if len(c.Args) > 2 {
+		unsupported(c)
+	}
+	if len(c.Args) == 1 {
+		t.str("set -e argv[1]")
+		return
+	}
+	n, ok := lit(c.Args[1])
+	switch {
+	case !ok:
+		// argv[1..0] would remove the first element, so slice from the remainder instead
+		t.str("set argv $argv[(math ")
+		t.word(c.Args[1], true)
+		t.str(" + 1)..]")
+	case n == "0":
+		t.str("true")
+	case n == "1":
+		t.str("set -e argv[1]")
+	case argvRe.MatchString(n):
+		t.printf("set -e argv[1..%s]", n)
+	default:
+		unsupported(c)
+	}
+}
+
+// set translates the set builtin, which either changes shell options or sets the positional parameters.
+// It returns false if the call should be translated as is.
+func (t *Translator) set(c *syntax.CallExpr) bool {
+	if len(c.Args) < 2 {
+		return false
+	}
+	var (
+		options    []string
+		positional []*syntax.Word
+		setArgv    bool
+	)
+	for i := 1; i < len(c.Args); i++ {
+		flags, ok := lit(c.Args[i])
+		if flags == "--" {
+			positional = c.Args[i+1:]
+			setArgv = true
+			break
+		}
+		if !ok || len(flags) < 2 || (flags[0] != '-' && flags[0] != '+') {
+			positional = c.Args[i:]
+			setArgv = true
+			break
+		}
+		on := flags[0] == '-'
+		for _, f := range flags[1:] {
+			name := ""
+			switch f {
+			case 'e':
+				name = "errexit"
+			case 'u':
+				name = "nounset"
+			case 'x':
+				name = "xtrace"
+			case 'o':
+				i++
+				if i >= len(c.Args) {
+					// Without a name, set -o lists the options, which don't exist in fish
+					t.warnf(c, "set %co lists bash options, which fish doesn't have", flags[0])
+					t.str("true")
+					return true
+				}
+				if name, ok = lit(c.Args[i]); !ok {
+					unsupported(c)
+				}
+			default:
+				unsupportedf(c, "set option %c%c", flags[0], f)
+			}
+			if !on {
+				name = "+" + name
+			}
+			options = append(options, name)
+		}
+	}
+
+	var out []string
+	for _, option := range options {
+		on := !strings.HasPrefix(option, "+")
+		switch strings.TrimPrefix(option, "+") {
+		case "errexit":
+			t.errexit = on
+		case "nounset":
+			t.nounset = on
+		case "pipefail":
+			t.pipefail = on
+		case "xtrace":
+			if on {
+				out = append(out, "set -g fish_trace 1")
+			} else {
+				out = append(out, "set -e fish_trace")
+			}
+		case "vi":
+			if on {
+				out = append(out, "fish_vi_key_bindings")
+			}
+		case "emacs":
+			if on {
+				out = append(out, "fish_default_key_bindings")
+			}
+		default:
+			unsupportedf(c, "set option %s", option)
+		}
+	}
+
+	if len(out) == 0 && !setArgv {
+		t.str("true")
+		return true
+	}
+	for i, o := range out {
+		if i > 0 {
+			t.nl()
+		}
+		for j, line := range strings.Split(o, "\n") {
+			if j > 0 {
+				t.nl()
+			}
+			t.str(line)
+		}
+	}
+	if setArgv {
+		if len(out) > 0 {
+			t.nl()
+		}
+		t.str("set argv")
+		for _, w := range positional {
+			t.str(" ")
+			t.word(w, false)
+		}
+	}
+	return true
+}
+
+// shoptOptions are the shopt options that change how the rest of the file is translated
+var shoptOptions = map[string]bool{
+	"nullglob":   true,
+	"failglob":   true,
+	"dotglob":    true,
+	"nocaseglob": true,
+	"globstar":   true,
+	"xpg_echo":   true,
+}
+
+// shopt tracks the options set with shopt -s and unset with shopt -u.
+// This happens statically, in the order they appear in the file.
+func (t *Translator) shopt(c *syntax.CallExpr) {
+	if len(c.Args) < 3 {
+		unsupportedf(c, "shopt needs -s or -u and an option")
+	}
+	flag, _ := lit(c.Args[1])
+	if flag != "-s" && flag != "-u" {
+		unsupportedf(c, "shopt %s", flag)
+	}
+	for _, a := range c.Args[2:] {
+		name, ok := lit(a)
+		switch {
+		case !ok:
+			unsupportedf(a, "shopt needs a static option name")
+		case name == "nocasematch":
+			// This would change every case and [[ == ]] in the rest of the file
+			unsupportedf(a, "shopt option %s", name)
+		case !shoptOptions[name]:
+			t.warnf(a, "shopt option %s doesn't change the translation", name)
+		}
+		t.shopts[name] = flag == "-s"
+	}
+	t.str("true")
+}
+
+// getopts translates a call to getopts into the helper that emulates it, which keeps track of OPTIND and OPTARG
+func (t *Translator) getopts(c *syntax.CallExpr) {
+	if len(c.Args) < 3 {
+		unsupportedf(c, "getopts needs an option string and a name")
+	}
+	t.str(t.helper("__babelfish_getopts"))
+	for _, a := range c.Args[1:] {
+		t.str(" ")
+		t.word(a, false)
+	}
+	if len(c.Args) == 3 {
+		t.str(" $argv")
+	}
+}
+
+// getoptsOption is an option from the option string of getopts
+type getoptsOption struct {
+	name   byte
+	hasArg bool
+	item   *syntax.CaseItem
+}
+
+// getoptsLoop translates the usual way of parsing options with getopts into argparse, returning false for any other loop:
+//
+//	while getopts "ab:" opt; do
+//	  case $opt in
+//	    a) ... ;;
+//	    b) ... "$OPTARG" ;;
+//	    \?) ... ;;
+//	  esac
+//	done
+//
+// The branches run in the order of the option string, instead of the order of the arguments.
+func (t *Translator) getoptsLoop(c *syntax.WhileClause) bool {
+	if c.Until || len(c.Cond) != 1 || len(c.Do) != 1 || len(c.Cond[0].Redirs) > 0 || len(c.Do[0].Redirs) > 0 {
+		return false
+	}
+	call, ok := c.Cond[0].Cmd.(*syntax.CallExpr)
+	if !ok || c.Cond[0].Negated || len(call.Assigns) > 0 || len(call.Args) != 3 {
+		return false
+	}
+	cc, ok := c.Do[0].Cmd.(*syntax.CaseClause)
+	if cmd, _ := lit(call.Args[0]); !ok || cmd != "getopts" {
+		return false
+	}
+	spec, ok := staticWord(call.Args[1])
+	if !ok {
+		return false
+	}
+	name, ok := lit(call.Args[2])
+	if !ok || !syntax.ValidName(name) || caseParam(cc.Word) != name {
+		return false
+	}
+
+	silent := strings.HasPrefix(spec, ":")
+	spec = strings.TrimPrefix(spec, ":")
+	var options []*getoptsOption
+	byName := map[string]*getoptsOption{}
+	for i := 0; i < len(spec); i++ {
+		c := spec[i]
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9') {
+			return false
+		}
+		o := &getoptsOption{name: c, hasArg: i+1 < len(spec) && spec[i+1] == ':'}
+		if o.hasArg {
+			i++
+		}
+		options = append(options, o)
+		byName[string(c)] = o
+	}
+
+	// The branch for invalid options and missing arguments, which argparse doesn't tell apart
+	var invalid *syntax.CaseItem
+	for _, item := range cc.Items {
+		// The loop goes away, so break and continue need the runtime helper
+		if item.Op != syntax.Break || loopControl(item) {
+			return false
+		}
+		for _, pat := range item.Patterns {
+			p, ok := staticWord(pat)
+			if !ok {
+				return false
+			}
+			switch o := byName[p]; {
+			case o != nil:
+				if o.item == nil {
+					o.item = item
+				}
+			case p == "?" || p == ":" || p == "*":
+				if invalid == nil {
+					invalid = item
+				}
+			case len(p) != 1:
+				return false
+			}
+		}
+	}
+
+	t.str("argparse -s")
+	for _, o := range options {
+		if o.hasArg {
+			t.printf(" '%c='", o.name)
+		} else {
+			t.printf(" '%c'", o.name)
+		}
+	}
+	t.str(" -- $argv")
+	if silent {
+		t.str(" 2>/dev/null")
+	}
+	if invalid != nil {
+		t.nl()
+		t.str("or begin")
+		t.indent()
+		if usesParam(invalid, name) {
+			t.printf("set -l %s '?'", t.varName(name))
+			t.nl()
+		}
+		t.body(invalid.Stmts...)
+		t.outdent()
+		t.str("end")
+	}
+	// argparse removes the options from argv, so shift $((OPTIND - 1)) shouldn't remove anything
+	t.nl()
+	t.str("set -l OPTIND 1")
+	for _, o := range options {
+		if o.item == nil {
+			continue
+		}
+		t.nl()
+		t.printf("if set -q _flag_%c", o.name)
+		t.indent()
+		if o.hasArg {
+			t.printf("set -l OPTARG $_flag_%c", o.name)
+			t.nl()
+		}
+		if usesParam(o.item, name) {
+			t.printf("set -l %s %c", t.varName(name), o.name)
+			t.nl()
+		}
+		t.body(o.item.Stmts...)
+		t.outdent()
+		t.str("end")
+	}
+	return true
+}
+
+// caseParam returns the name of the variable a case statement matches on, like $opt or "$opt"
+func caseParam(w *syntax.Word) string {
+	parts := w.Parts
+	if len(parts) == 1 {
+		if dq, ok := parts[0].(*syntax.DblQuoted); ok {
+			parts = dq.Parts
+		}
+	}
+	if len(parts) != 1 || !simpleParamExp(parts[0]) {
+		return ""
+	}
+	return parts[0].(*syntax.ParamExp).Param.Value
+}
+
+// loopControl returns whether a break or continue in n applies to a loop around it
+func loopControl(n syntax.Node) bool {
+	found := false
+	syntax.Walk(n, func(n syntax.Node) bool {
+		switch n := n.(type) {
+		case *syntax.ForClause, *syntax.WhileClause, *syntax.FuncDecl:
+			return false
+		case *syntax.CallExpr:
+			if len(n.Args) > 0 {
+				if l, _ := lit(n.Args[0]); l == "break" || l == "continue" {
+					found = true
+				}
+			}
+		}
+		return !found
+	})
+	return found
+}
+
+// usesParam returns whether a node expands the given variable
+func usesParam(n syntax.Node, name string) bool {
+	found := false
+	syntax.Walk(n, func(n syntax.Node) bool {
+		if p, ok := n.(*syntax.ParamExp); ok && p.Param.Value == name {
+			found = true
+		}
+		return !found
+	})
+	return found
+}
+
+// read translates the read builtin, most of whose options are different in fish
+func (t *Translator) read(c *syntax.CallExpr) {
+	var (
+		opts  []string
+		fd    string
+		list  *syntax.Word
+		names []*syntax.Word
+	)
+	word := func(w *syntax.Word) string {
+		return t.sideBuffer(func() {
+			t.word(w, true)
+		})
+	}
+	args := c.Args[1:]
+	for len(args) > 0 {
+		flags, ok := lit(args[0])
+		if !ok || len(flags) < 2 || flags[0] != '-' {
+			break
+		}
+		args = args[1:]
+		if flags == "--" {
+			break
+		}
+		for i := 1; i < len(flags); i++ {
+			f := flags[i]
+			var value *syntax.Word
+			if strings.IndexByte("adinNptu", f) >= 0 {
+				switch {
+				case i+1 < len(flags):
+					value = &syntax.Word{Parts: []syntax.WordPart{&syntax.Lit{Value: flags[i+1:]}}}
+				case len(args) > 0:
+					value = args[0]
+					args = args[1:]
+				default:
+					unsupportedf(c, "read -%c needs an argument", f)
+				}
+				i = len(flags)
+			}
+			switch f {
+			case 'r', 'e':
+				// Fish never removes backslashes, and always uses its own line editor
+			case 'a':
+				list = value
+			case 'd':
+				switch d, ok := staticWord(value); {
+				case !ok:
+					t.warnf(c, "read -d with a dynamic delimiter has no equivalent in fish, it reads a line")
+				case d == "":
+					opts = append(opts, "--null")
+				case d != "\n":
+					t.warnf(c, "read -d %q has no equivalent in fish, it reads a line", d)
+				}
+			case 'i':
+				opts = append(opts, "--command "+word(value))
+			case 'n', 'N':
+				opts = append(opts, "--nchars "+word(value))
+			case 'p':
+				opts = append(opts, "--prompt-str "+word(value))
+			case 's':
+				opts = append(opts, "--silent")
+			case 't':
+				t.warnf(c, "read -t has no equivalent in fish, it waits without a timeout")
+			case 'u':
+				var ok bool
+				if fd, ok = lit(value); !ok {
+					unsupportedf(c, "read -u needs a static file descriptor")
+				}
+			default:
+				unsupportedf(c, "read -%c", f)
+			}
+		}
+	}
+	names = args
+	if list != nil {
+		opts = append(opts, "--list")
+		names = []*syntax.Word{list}
+	}
+
+	// Fish splits on IFS too, but treats an empty one differently and the use of IFS is deprecated
+	ifsPrefix := false
+	if len(names) > 1 || list != nil {
+		switch {
+		case !t.ifsKnown:
+			ifsPrefix = true
+		case t.ifs == defaultIFS:
+		case t.ifs == "":
+			opts = append(opts, `--delimiter \n`)
+		case len(t.ifs) == 1:
+			opts = append(opts, "--delimiter "+t.sideBuffer(func() {
+				t.ansiCString(t.ifs)
+			}))
+		default:
+			ifsPrefix = true
+		}
+	}
+	for _, a := range c.Assigns {
+		if ifsPrefix && a.Name.Value == "IFS" && a.Value != nil {
+			t.str("IFS=")
+			t.assignWord(a.Value)
+			t.str(" ")
+		}
+	}
+
+	t.str("read")
+	for _, o := range opts {
+		t.str(" " + o)
+	}
+	if len(names) == 0 {
+		t.str(" REPLY")
+	}
+	for _, n := range names {
+		t.str(" ")
+		if name, ok := lit(n); ok && syntax.ValidName(name) {
+			t.str(t.varName(name))
+			continue
+		}
+		t.word(n, false)
+	}
+	if fd != "" {
+		t.printf(" <&%s", fd)
+	}
+}
+
+// mapfile translates mapfile and readarray, returning false for any other statement.
+// The lines are read by a command substitution, which gets the input redirection of the statement.
+func (t *Translator) mapfile(s *syntax.Stmt) bool {
+	c, ok := s.Cmd.(*syntax.CallExpr)
+	if !ok || len(c.Args) == 0 || len(c.Assigns) > 0 {
+		return false
+	}
+	if cmd, _ := lit(c.Args[0]); cmd != "mapfile" && cmd != "readarray" {
+		return false
+	}
+
+	var (
+		opts     []string
+		trim     bool
+		origin   int
+		callback string
+		quantum  = 5000
+		fd       string
+	)
+	args := c.Args[1:]
+	for len(args) > 0 {
+		flags, ok := lit(args[0])
+		if !ok || len(flags) < 2 || flags[0] != '-' {
+			break
+		}
+		args = args[1:]
+		if flags == "--" {
+			break
+		}
+		for i := 1; i < len(flags); i++ {
+			f := flags[i]
+			if f == 't' {
+				trim = true
+				continue
+			}
+			if strings.IndexByte("dnOscCu", f) < 0 {
+				unsupportedf(c, "mapfile -%c", f)
+			}
+			var value string
+			switch {
+			case i+1 < len(flags):
+				value = flags[i+1:]
+			case len(args) > 0:
+				if value, ok = staticWord(args[0]); !ok {
+					unsupportedf(args[0], "mapfile -%c needs a static value", f)
+				}
+				args = args[1:]
+			default:
+				unsupportedf(c, "mapfile -%c needs an argument", f)
+			}
+			i = len(flags)
+			switch f {
+			case 'd':
+				opts = append(opts, "-d "+t.sideBuffer(func() {
+					t.ansiCString(value)
+				}))
+			case 'n', 's':
+				opts = append(opts, fmt.Sprintf("-%c %s", f, value))
+			case 'O', 'c':
+				n, err := strconv.Atoi(value)
+				if err != nil || n < 0 || f == 'c' && n == 0 {
+					unsupportedf(c, "mapfile -%c %s", f, value)
+				}
+				if f == 'O' {
+					origin = n
+				} else {
+					quantum = n
+				}
+			case 'C':
+				callback = value
+			case 'u':
+				fd = value
+			}
+		}
+	}
+	name := "MAPFILE"
+	if len(args) > 1 {
+		unsupportedf(c, "mapfile only takes one array name")
+	} else if len(args) == 1 {
+		if name, ok = lit(args[0]); !ok || !syntax.ValidName(name) {
+			unsupportedf(args[0], "mapfile needs a static array name")
+		}
+	}
+	name = t.varName(name)
+
+	// Lines from a process substitution are piped in, other redirections go to the command reading them
+	var input []*syntax.Stmt
+	var redirs []*syntax.Redirect
+	for _, r := range s.Redirs {
+		if r.Op == syntax.RdrIn && r.N == nil && len(r.Word.Parts) == 1 {
+			if p, ok := r.Word.Parts[0].(*syntax.ProcSubst); ok && p.Op == syntax.CmdIn {
+				input = p.Stmts
+				continue
+			}
+		}
+		redirs = append(redirs, r)
+	}
+	if trim && len(opts) == 0 {
+		// Command substitutions split on newlines already
+		t.printf("set %s", name)
+		if origin > 0 {
+			t.printf(" $%s[1..%d]", name, origin)
+		}
+		t.str(" (")
+		switch {
+		case input != nil && len(redirs) == 0 && fd == "":
+			t.stmts(input...)
+		case input != nil:
+			t.stmts(input...)
+			t.str(" | cat")
+		default:
+			t.str("cat")
+		}
+	} else {
+		if !trim {
+			opts = append([]string{"-k"}, opts...)
+		}
+		t.printf("set %s", name)
+		if origin > 0 {
+			t.printf(" $%s[1..%d]", name, origin)
+		}
+		t.str(" (")
+		if input != nil {
+			t.stmts(input...)
+			t.str(" | ")
+		}
+		t.str(t.helper("__babelfish_mapfile"))
+		for _, o := range opts {
+			t.str(" " + o)
+		}
+	}
+	for _, r := range redirs {
+		t.str(" ")
+		t.redirect(s, r)
+	}
+	if fd != "" {
+		t.printf(" <&%s", fd)
+	}
+	if !trim || len(opts) > 0 {
+		t.str(" | string split0")
+	}
+	t.str(")")
+
+	if callback != "" {
+		// The callback gets the index of every quantum'th element, and the element itself
+		t.nl()
+		t.printf("for __babelfish_i in (seq %d %d (count $%s))", origin+quantum, quantum, name)
+		t.indent()
+		t.printf("%s (math $__babelfish_i - 1) \"$%s[$__babelfish_i]\"", callback, name)
+		t.outdent()
+		t.str("end")
+	}
+	return true
+}
+
+var printfConvRe = regexp.MustCompile(`%[-+ #0']*(\*|[0-9]+)?(\.(\*|[0-9]*))?(\([^)]*\)T|[a-zA-Z%])`)
+
+// printfCmd translates printf, which can assign to a variable with -v in bash and knows a couple more conversions.
+// The arguments for %q, %b and %(...)T are converted by fish and printed with %s.
+func (t *Translator) printfCmd(c *syntax.CallExpr) {
+	args := c.Args[1:]
+	name := ""
+	for len(args) > 0 {
+		flag, _ := lit(args[0])
+		if flag == "--" {
+			args = args[1:]
+			break
+		}
+		if flag != "-v" {
+			break
+		}
+		if len(args) < 2 {
+			unsupportedf(c, "printf -v needs a variable name")
+		}
+		var ok bool
+		if name, ok = lit(args[1]); !ok || !syntax.ValidName(name) {
+			unsupportedf(args[1], "printf -v needs a static variable name")
+		}
+		args = args[2:]
+	}
+	if len(args) == 0 {
+		unsupportedf(c, "printf needs a format")
+	}
+
+	// conv holds the conversion of every argument in the format, which is reused until all arguments are used
+	var conv []string
+	format, static := staticWord(args[0])
+	if static {
+		format = printfConvRe.ReplaceAllStringFunc(format, func(spec string) string {
+			m := printfConvRe.FindStringSubmatch(spec)
+			if m[1] == "*" {
+				conv = append(conv, "")
+			}
+			if m[3] == "*" {
+				conv = append(conv, "")
+			}
+			switch verb := m[4]; {
+			case verb == "%":
+				return spec
+			case verb == "q", verb == "b", strings.HasSuffix(verb, "T"):
+				conv = append(conv, verb)
+				return strings.TrimSuffix(spec, verb) + "s"
+			default:
+				conv = append(conv, "")
+				return spec
+			}
+		})
+	}
+	converts := false
+	for _, v := range conv {
+		converts = converts || v != ""
+	}
+	if converts {
+		// Every argument needs to be known to be a single one, to know which conversion it gets.
+		// That doesn't matter if they all get %q, because string escape escapes every argument.
+		for _, a := range args[1:] {
+			if len(conv) == 1 && conv[0] == "q" {
+				break
+			}
+			for _, p := range flattenWord(a, false) {
+				if _, ok := t.listParam(p.part); ok {
+					unsupportedf(a, "printf can't convert the elements of a list")
+				}
+			}
+			if !t.noWordSplitting && splittableWord(a) && !quotedWord(a) {
+				unsupportedf(a, "printf arguments need to be quoted to convert them")
+			}
+		}
+	}
+
+	if name != "" {
+		scope := ""
+		if t.inFunction && !t.locals[name] && !t.namerefs[name] {
+			// Bash sets a global, unless the variable was declared local
+			scope = " -g"
+		}
+		t.printf("set%s %s (", scope, t.varName(name))
+	}
+	t.str("printf ")
+	if converts {
+		t.escapedString(format)
+	} else {
+		t.arg(args[0])
+	}
+	for i, a := range args[1:] {
+		t.str(" ")
+		verb := ""
+		if converts {
+			verb = conv[i%len(conv)]
+		}
+		switch {
+		case verb == "q":
+			t.str("(string escape -- ")
+			t.word(a, false)
+			t.str(")")
+		case verb == "b":
+			t.str("(echo -ne -- ")
+			t.word(a, false)
+			t.str(" | string collect -N; or echo)")
+		case strings.HasSuffix(verb, "T"):
+			switch ts, _ := staticWord(a); ts {
+			case "-1":
+				t.dateCmd(a, verb, nil)
+			case "-2":
+				// The time the shell started, which the helper records at the top of the script
+				t.helper("__babelfish_seconds")
+				t.dateCmd(a, verb, func() { t.str("$__babelfish_start") })
+			default:
+				t.dateCmd(a, verb, func() { t.word(a, true) })
+			}
+		default:
+			t.arg(a)
+		}
+	}
+	// %(...)T without an argument prints the current time
+	if converts && len(args) == 1 {
+		for _, verb := range conv {
+			if strings.HasSuffix(verb, "T") {
+				t.str(" ")
+				t.dateCmd(c, verb, nil)
+			} else {
+				t.str(" ''")
+			}
+		}
+	}
+	if name != "" {
+		t.str(" | string collect -N; or echo)")
+	}
+}
+
+// dateCmd writes a date command for a %(fmt)T conversion, for the number of seconds since the epoch that seconds writes, or now
+func (t *Translator) dateCmd(n syntax.Node, verb string, seconds func()) {
+	format := strings.TrimSuffix(strings.TrimPrefix(verb, "("), ")T")
+	if format == "" {
+		format = "%X"
+	}
+	t.str("(date ")
+	if seconds != nil {
+		t.warnf(n, "printf %%%s formats a given time with date -d, which only GNU date supports", verb)
+		t.str("-d @")
+		seconds()
+		t.str(" ")
+	}
+	t.escapedString("+" + format)
+	t.str(")")
+}
+
+// quotedWord returns whether a word is quoted as a whole, so it's always one argument
+func quotedWord(w *syntax.Word) bool {
+	if len(w.Parts) != 1 {
+		return false
+	}
+	switch w.Parts[0].(type) {
+	case *syntax.SglQuoted, *syntax.DblQuoted:
+		return true
+	}
+	return false
+}
+
+// splittableWord returns whether a word could be split into multiple arguments
+func splittableWord(w *syntax.Word) bool {
+	for _, part := range w.Parts {
+		switch part := part.(type) {
+		case *syntax.ParamExp, *syntax.CmdSubst:
+			return true
+		case *syntax.Lit:
+			if hasGlob(&syntax.Word{Parts: []syntax.WordPart{part}}) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// echoFlagRe matches the arguments bash's echo takes as options
+var echoFlagRe = regexp.MustCompile(`^-[neE]+$`)
+
+// echo translates echo, whose options are parsed statically where possible.
+// Fish's echo also takes -s and --, so an argument that starts with a dash is separated with --.
+// When the first argument isn't known, it could still be an option to bash, which the helper handles at runtime.
+func (t *Translator) echo(c *syntax.CallExpr) {
+	args := c.Args[1:]
+	newline, escapes := true, t.shopts["xpg_echo"]
+	for len(args) > 0 {
+		flag, ok := staticWord(args[0])
+		if !ok || hasGlob(args[0]) || !echoFlagRe.MatchString(flag) {
+			break
+		}
+		for _, f := range flag[1:] {
+			switch f {
+			case 'n':
+				newline = false
+			case 'e':
+				escapes = true
+			case 'E':
+				escapes = false
+			}
+		}
+		args = args[1:]
+	}
+
+	name, sep := "echo", false
+	if len(args) > 0 {
+		if s, ok := staticWord(args[0]); ok && !hasGlob(args[0]) {
+			sep = strings.HasPrefix(s, "-")
+		} else if !echoSafe(args[0]) {
+			name = t.helper("__babelfish_echo")
+		}
+	}
+	t.str(name)
+	if !newline || escapes {
+		t.str(" -")
+		if !newline {
+			t.str("n")
+		}
+		if escapes {
+			t.str("e")
+		}
+	}
+	if sep {
+		t.str(" --")
+	}
+	for _, a := range args {
+		t.str(" ")
+		if !t.glob(a, false) {
+			t.arg(a)
+		}
+	}
+}
+
+// echoSafeParams are the special parameters that expand to numbers or versions, which are never options
+var echoSafeParams = map[string]bool{
+	"#": true, "?": true, "$": true, "!": true,
+	"PPID": true, "RANDOM": true, "SRANDOM": true, "SECONDS": true, "LINENO": true,
+	"EPOCHSECONDS": true, "BASHPID": true, "BASH_PID": true,
+	"BASH_VERSION": true, "BASH_VERSINFO": true, "PIPESTATUS": true,
+	"OSTYPE": true, "MACHTYPE": true, "HOSTTYPE": true,
+}
+
+// echoSafe returns whether a word that isn't static is never taken as an option by either echo.
+// That's the case when it contains a character that isn't in an option, or one of the parameters that are never options.
+func echoSafe(w *syntax.Word) bool {
+	if hasGlob(w) {
+		// The glob could match anything, so only a fixed first character helps
+		lit, ok := w.Parts[0].(*syntax.Lit)
+		return ok && lit.Value != "" && !strings.ContainsRune("-*?[\\", rune(lit.Value[0]))
+	}
+	for _, p := range flattenWord(w, false) {
+		switch part := p.part.(type) {
+		case *syntax.Lit:
+			q := quoteNone
+			if p.quoted {
+				q = quoteDouble
+			}
+			if strings.Trim(unescapeQuoted(part.Value, q), "-neEs") != "" {
+				return true
+			}
+		case *syntax.SglQuoted:
+			value := part.Value
+			if part.Dollar {
+				value = decodeANSIC(value)
+			}
+			if strings.Trim(value, "-neEs") != "" {
+				return true
+			}
+		case *syntax.ArithmExp:
+			return true
+		case *syntax.ParamExp:
+			switch {
+			case part.Length, echoSafeParams[part.Param.Value] && part.Exp == nil && part.Repl == nil:
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// aliasNameRe matches the alias names that can be used as the name of a fish function
+var aliasNameRe = regexp.MustCompile(`^[A-Za-z0-9_.@%+:,^][A-Za-z0-9_.@%+:,^-]*$`)
+
+// alias translates alias definitions into functions, like fish's alias does.
+// The body is translated as bash, with the arguments added to its last command, where bash expands them.
+func (t *Translator) alias(c *syntax.CallExpr) {
+	first := true
+	for _, a := range c.Args[1:] {
+		def, ok := staticWord(a)
+		if !ok {
+			unsupportedf(a, "alias needs a static definition")
+		}
+		if !first {
+			t.nl()
+		}
+		first = false
+		if def == "-p" {
+			t.str("alias")
+			continue
+		}
+		name, value, ok := strings.Cut(def, "=")
+		if !ok {
+			unsupportedf(a, "alias can only print all aliases")
+		}
+		if !aliasNameRe.MatchString(name) {
+			unsupportedf(a, "alias name %q can't be a function name", name)
+		}
+
+		t.printf("function %s", name)
+		src := value
+		if fields := strings.Fields(value); len(fields) > 0 {
+			if fields[0] == name {
+				// The alias isn't expanded again inside of itself
+				src = "command " + value
+			} else {
+				t.str(" --wraps ")
+				t.escapedString(value)
+			}
+		}
+		t.str(" --description ")
+		t.escapedString("alias " + def)
+		f, err := syntax.NewParser(syntax.Variant(syntax.LangBash)).Parse(strings.NewReader(src), "")
+		if err != nil {
+			unsupportedf(a, "can't parse %q: %v", src, err)
+		}
+		t.indent()
+		t.functionScope(func() {
+			t.body(aliasArgs(a, f.Stmts)...)
+		})
+		t.outdent()
+		t.str("end")
+	}
+	if first {
+		t.str("alias")
+	}
+}
+
+// aliasArgs adds "$@" to the last command of an alias body
+func aliasArgs(n syntax.Node, stmts []*syntax.Stmt) []*syntax.Stmt {
+	args := &syntax.Word{Parts: []syntax.WordPart{&syntax.DblQuoted{Parts: []syntax.WordPart{
+		&syntax.ParamExp{Param: &syntax.Lit{Value: "@"}},
+	}}}}
+	if len(stmts) == 0 {
+		return []*syntax.Stmt{{Cmd: &syntax.CallExpr{Args: []*syntax.Word{args}}}}
+	}
+	s := stmts[len(stmts)-1]
+	for {
+		bc, ok := s.Cmd.(*syntax.BinaryCmd)
+		if !ok {
+			break
+		}
+		s = bc.Y
+	}
+	c, ok := s.Cmd.(*syntax.CallExpr)
+	if !ok || len(c.Args) == 0 {
+		unsupportedf(n, "alias body needs to end with a command to take the arguments")
+	}
+	c.Args = append(c.Args, args)
+	return stmts
+}
+
+// unalias removes the functions that alias defined.
+// Fish's alias lists the functions it defined, so unalias -a removes those.
+func (t *Translator) unalias(c *syntax.CallExpr) {
+	t.str("functions -e")
+	for _, a := range c.Args[1:] {
+		if flag, _ := lit(a); flag == "-a" {
+			t.str(" (alias | string split -f 2 ' ')")
+			continue
+		}
+		t.str(" ")
+		t.arg(a)
+	}
+}
+
+// evalSource returns the code given to eval, if it's known statically
+func evalSource(c *syntax.CallExpr) (string, bool) {
+	src := make([]string, 0, len(c.Args)-1)
+	for _, a := range c.Args[1:] {
+		s, ok := staticWord(a)
+		if !ok {
+			return "", false
+		}
+		src = append(src, s)
+	}
+	return strings.Join(src, " "), true
+}
+
+// eval translates the code when it's known statically.
+// Otherwise the code is translated by babelfish when it runs, and the status of source is the status of the code.
+// Assignments before eval apply to all of the code, so they're made in a block around it.
+func (t *Translator) eval(c *syntax.CallExpr) bool {
+	block := func(f func()) {
+		t.str("begin")
+		t.indent()
+		for _, a := range c.Assigns {
+			t.printf("set -lx %s ", a.Name.Value)
+			if a.Value != nil {
+				t.assignWord(a.Value)
+			} else {
+				t.str("''")
+			}
+			t.nl()
+		}
+		f()
+		t.outdent()
+		t.str("end")
+	}
+
+	if code, ok := evalSource(c); ok {
+		f, err := syntax.NewParser(syntax.KeepComments(true), syntax.Variant(syntax.LangBash)).Parse(strings.NewReader(code), "")
+		if err != nil {
+			unsupportedf(c, "can't parse %q: %v", code, err)
+		}
+		switch {
+		case len(c.Assigns) == 0 && len(f.Stmts) == 0:
+			t.str("true")
+		case len(c.Assigns) == 0 && len(f.Stmts) == 1:
+			t.body(f.Stmts...)
+		default:
+			// Keep the statements together, in case eval is part of a pipeline or && list
+			block(func() {
+				if len(f.Stmts) == 0 {
+					t.str("true")
+				}
+				t.body(f.Stmts...)
+			})
+		}
+		return true
+	}
+
+	if t.babelFishLocation == "" {
+		return false
+	}
+	run := func() {
+		t.str("string join ' ' --")
+		for _, a := range c.Args[1:] {
+			t.str(" ")
+			if !t.glob(a, false) {
+				t.arg(a)
+			}
+		}
+		t.str(" | ")
+		t.babelfish()
+		if t.sourcePath != "" {
+			t.str(" -source-path ")
+			t.escapedString(t.sourcePath)
+		}
+		t.str(" | source")
+	}
+	if len(c.Assigns) > 0 {
+		block(run)
+	} else {
+		run()
+	}
+	return true
+}
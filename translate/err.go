@@ -6,13 +6,26 @@ import (
 )
 
 type UnsupportedError struct {
-	Node syntax.Node
+	Node   syntax.Node
+	Reason string
 }
 
 func (u *UnsupportedError) Error() string {
+	if u.Reason != "" {
+		return fmt.Sprintf("unsupported: %s at %s", u.Reason, u.Node.Pos())
+	}
 	return fmt.Sprintf("unsupported: %#v", u.Node)
 }
 
 func unsupported(n syntax.Node) {
-	panic(&UnsupportedError{n})
+	panic(&UnsupportedError{Node: n})
+}
+
+func unsupportedf(n syntax.Node, format string, args ...interface{}) {
+	panic(&UnsupportedError{Node: n, Reason: fmt.Sprintf(format, args...)})
+}
+
+// warnf records something that the translation can't reproduce exactly, but that doesn't stop it
+func (t *Translator) warnf(n syntax.Node, format string, args ...interface{}) {
+	t.warnings = append(t.warnings, fmt.Sprintf("%s at %s", fmt.Sprintf(format, args...), n.Pos()))
 }
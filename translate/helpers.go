@@ -0,0 +1,183 @@
+package translate
+
+// helperFuncs are fish functions that the translated code can rely on.
+// They're written at the top of the output when used.
+var helperFuncs = map[string]string{
+	"__babelfish_ifs_join": `function __babelfish_ifs_join -d 'Join the arguments with the first character of IFS, like "$*"'
+  set -l sep ' '
+  set -q IFS; and set sep (string sub -l 1 -- "$IFS")
+  string join -- "$sep" $argv
+end`,
+	"__babelfish_ifs_split": `function __babelfish_ifs_split -d 'Split the arguments on the characters of IFS like an unquoted expansion, printing every field followed by a NUL byte'
+  for sep in (string split '' -- "$IFS")
+    test -n "$sep"; and set argv (string split -n -- $sep $argv)
+  end
+  string join0 -- $argv
+end`,
+	"__babelfish_return_trap": `function __babelfish_return_trap -d 'Run the RETURN trap of the function that is returning, keeping its status'
+  set -l s $status
+  if functions -q __babelfish_trap_RETURN
+    __babelfish_trap_RETURN
+    functions -e __babelfish_trap_RETURN
+  end
+  return $s
+end`,
+	"__babelfish_pipefail": `function __babelfish_pipefail -d 'Return the last non-zero status it is given, like set -o pipefail'
+  for s in $argv[-1..1]
+    test $s -ne 0; and return $s
+  end
+  return 0
+end`,
+	"__babelfish_concat_list": `function __babelfish_concat_list -d 'Add a prefix to the first and a suffix to the last element, like "prefix$@suffix"'
+  set -l prefix $argv[1]
+  set -l suffix $argv[2]
+  set -e argv[1..2]
+  if test (count $argv) -eq 0
+    printf '%s\0' "$prefix$suffix"
+    return
+  end
+  set argv[1] "$prefix$argv[1]"
+  set argv[-1] "$argv[-1]$suffix"
+  printf '%s\0' $argv
+end`,
+	"__babelfish_seconds": `set -q __babelfish_start; or set -g __babelfish_start (date +%s)
+function __babelfish_seconds -d 'The number of seconds since the script started, like $SECONDS'
+  math (date +%s) - $__babelfish_start
+end`,
+	"__babelfish_caller": `function __babelfish_caller -d 'Print the line, function and file of a caller, like caller'
+  set -l funcs
+  set -l lines
+  set -l files
+  for l in (status stack-trace)
+    switch $l
+      case 'in function *'
+        set -a funcs (string replace -r "^in function '([^']*)'.*" '$1' -- $l)
+        set -a lines ''
+        set -a files ''
+      case 'from sourcing file *'
+        set -a funcs main
+        set -a lines ''
+        set -a files ''
+      case '*called on line *'
+        set lines[-1] (string replace -r '.*called on line (\d+) of file .*' '$1' -- $l)
+        set files[-1] (string replace -r '.*called on line \d+ of file ' '' -- $l)
+    end
+  end
+  # The first frame is this function, the second the function that wants to know its caller
+  set -l i 2
+  set -q argv[1]; and set i (math $argv[1] + 2)
+  set -q lines[$i]; and test -n "$lines[$i]"; or return 1
+  if not set -q argv[1]
+    echo $lines[$i] $files[$i]
+    return
+  end
+  set -l func main
+  set -q funcs[(math $i + 1)]; and set func $funcs[(math $i + 1)]
+  echo $lines[$i] $func $files[$i]
+end`,
+	"__babelfish_getopts": `function __babelfish_getopts -S -d 'Parse the next option like getopts, keeping track of the position in OPTIND'
+  set -l spec $argv[1]
+  set -l name $argv[2]
+  set -e argv[1..2]
+  set -q OPTIND; or set -g OPTIND 1
+  set -q __babelfish_optpos; or set -g __babelfish_optpos 2
+  set -q $name; or set -g $name
+  set -e OPTARG
+  set -l arg
+  test $OPTIND -le (count $argv); and set arg $argv[$OPTIND]
+  if test "$arg" = --
+    set OPTIND (math $OPTIND + 1)
+  end
+  if test "$arg" = --; or not string match -qr '^-.' -- "$arg"
+    set $name '?'
+    set __babelfish_optpos 2
+    return 1
+  end
+  set -l opt (string sub -s $__babelfish_optpos -l 1 -- $arg)
+  set -l rest (string sub -s (math $__babelfish_optpos + 1) -- $arg)
+  set __babelfish_optpos (math $__babelfish_optpos + 1)
+  if test -z "$rest"
+    set OPTIND (math $OPTIND + 1)
+    set __babelfish_optpos 2
+  end
+  set -l silent (string match -r '^:' -- $spec)
+  set -l chars (string split '' -- (string replace -r '^:' '' -- $spec))
+  set -l i (contains -i -- $opt $chars)
+  if test -z "$i"; or test "$opt" = :
+    set $name '?'
+    if set -q silent[1]
+      set -g OPTARG $opt
+    else
+      echo "illegal option -- $opt" >&2
+    end
+    return 0
+  end
+  set $name $opt
+  set -l next (math $i + 1)
+  if set -q chars[$next]; and test "$chars[$next]" = :
+    if test -n "$rest"
+      set -g OPTARG $rest
+      set OPTIND (math $OPTIND + 1)
+      set __babelfish_optpos 2
+    else if test $OPTIND -le (count $argv)
+      set -g OPTARG $argv[$OPTIND]
+      set OPTIND (math $OPTIND + 1)
+    else if set -q silent[1]
+      set $name :
+      set -g OPTARG $opt
+    else
+      set $name '?'
+      echo "option requires an argument -- $opt" >&2
+    end
+  end
+  return 0
+end`,
+	"__babelfish_mapfile": `function __babelfish_mapfile -d 'Read lines like mapfile, printing every one of them followed by a NUL byte'
+  argparse k d= n= s= -- $argv; or return
+  set -l lines
+  if set -q _flag_d; and test -z "$_flag_d"
+    set lines (string split0)
+  else
+    set -l delim \n
+    set -q _flag_d; and set delim (string sub -l 1 -- $_flag_d)
+    set -l input (string collect -N)
+    set lines (string split -- $delim "$input")
+    # The input ends with a delimiter, which leaves an empty element at the end
+    test -z "$lines[-1]"; and set -e lines[-1]
+    # Without -t the delimiter is kept
+    set -q _flag_k; and set lines $lines$delim
+  end
+  set -q _flag_s; and set lines $lines[(math $_flag_s + 1)..-1]
+  set -q _flag_n; and test $_flag_n -gt 0; and set lines $lines[1..$_flag_n]
+  set -q lines[1]; and printf '%s\0' $lines
+end`,
+	"__babelfish_echo": `function __babelfish_echo -d 'Print the arguments, taking the same options as bash echo'
+  set -l opts
+  while set -q argv[1]; and string match -qr -- '^-[neE]+$' $argv[1]
+    set -a opts $argv[1]
+    set -e argv[1]
+  end
+  echo $opts -- $argv
+end`,
+	"__babelfish_mkfifo": `function __babelfish_mkfifo -d 'Create a FIFO for >(cmd), which is removed when the shell exits'
+  set -l fifo (mktemp -u)
+  mkfifo $fifo
+  set -ga __babelfish_fifos $fifo
+  echo $fifo
+end
+function __babelfish_fifo_cleanup --on-event fish_exit
+  rm -f $__babelfish_fifos
+end`,
+}
+
+// helper marks a helper function as used, and returns its name
+func (t *Translator) helper(name string) string {
+	if _, ok := helperFuncs[name]; !ok {
+		panic("unknown helper " + name)
+	}
+	if !t.helpersUsed[name] {
+		t.helpersUsed[name] = true
+		t.helpers = append(t.helpers, name)
+	}
+	return name
+}
@@ -5,7 +5,10 @@ import (
 	"fmt"
 	"io"
 	"regexp"
+	"strconv"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 
 	"mvdan.cc/sh/v3/pattern"
 	"mvdan.cc/sh/v3/syntax"
@@ -18,18 +21,120 @@ type Translator struct {
 	buf               *bytes.Buffer
 	indentLevel       int
 	babelFishLocation string
+	bashVersion       string
+	sourcePath        string
+	noWordSplitting   bool
+	gettext           bool
+
+	// inFunction is set while translating the body of a function
+	inFunction bool
+	// returnTrap is set while translating a function that sets a RETURN trap, which runs whenever it returns
+	returnTrap bool
+	// varAttrs holds the attributes set through declare and friends, so later assignments can respect them
+	varAttrs map[string]varAttr
+	// namerefs holds the variables declared with declare -n in the current function.
+	// The fish variable holds the name of the target, so it's dereferenced with $$
+	namerefs map[string]bool
+	// locals holds the variables declared with local in the current function
+	locals map[string]bool
+
+	// Shell options set with set -e, set -u, set -o pipefail
+	errexit  bool
+	nounset  bool
+	pipefail bool
+	// shopts holds the options set with shopt -s
+	shopts map[string]bool
+	// inPipe is set while translating the commands of a pipeline
+	inPipe bool
+	// quote is the bash quoting context of what's being translated
+	quote quoteState
+	// inBrace is set while translating the elements of a brace expansion
+	inBrace bool
+	// pattern is set while translating a pattern, where escaped and quoted wildcards match literally
+	pattern bool
+	// concat is set while translating the parts of a word that are concatenated with each other
+	concat bool
+	// assignment is set when the next word is the value of an assignment, see assignWord
+	assignment bool
+	// substStatus is set while translating an assignment under set -e,
+	// so its command substitutions save their status for the check after it
+	substStatus bool
+	// ifs is the value of IFS, if it's known statically
+	ifs      string
+	ifsKnown bool
+
+	// setup holds code that needs to run before the statement that's being translated
+	setup []string
+	// names counts the generated names, to keep them unique
+	names int
+
+	// warnings are the problems with the translation that didn't stop it
+	warnings []string
+
+	// helpers are the helper functions used by the translation, in order of first use
+	helpers     []string
+	helpersUsed map[string]bool
 }
 
+// varAttr is a set of bash variable attributes, as set by declare -ilur
+type varAttr uint8
+
+const (
+	attrInteger varAttr = 1 << iota
+	attrLower
+	attrUpper
+	attrReadonly
+)
+
 func NewTranslator() *Translator {
 	return &Translator{
-		buf: &bytes.Buffer{},
+		buf:         &bytes.Buffer{},
+		varAttrs:    map[string]varAttr{},
+		namerefs:    map[string]bool{},
+		locals:      map[string]bool{},
+		shopts:      map[string]bool{},
+		helpersUsed: map[string]bool{},
+		ifs:         defaultIFS,
+		ifsKnown:    true,
+		bashVersion: DefaultBashVersion,
 	}
 }
 
+// SourcePath sets the path of the bash script, for when the translation is sourced right away like babel.fish does.
+// Fish only knows the translation is read from stdin then, so $0 and $BASH_SOURCE refer to this path instead.
+func (t *Translator) SourcePath(path string) {
+	t.sourcePath = path
+}
+
+// Warnings returns the parts of the translation that don't behave exactly like bash
+func (t *Translator) Warnings() []string {
+	return t.warnings
+}
+
+// DefaultBashVersion is the version of bash that $BASH_VERSION reports by default
+const DefaultBashVersion = "5.2.21(1)-release"
+
+// BashVersion sets the version that $BASH_VERSION and $BASH_VERSINFO report, like 5.2.21(1)-release
+func (t *Translator) BashVersion(version string) {
+	t.bashVersion = version
+}
+
 func (t *Translator) BabelfishLocation(loc string) {
 	t.babelFishLocation = loc
 }
 
+// WordSplitting controls whether unquoted expansions are split on IFS like bash does.
+// Scripts that always quote their expansions can disable it for a cleaner translation.
+func (t *Translator) WordSplitting(enabled bool) {
+	t.noWordSplitting = !enabled
+}
+
+// Gettext makes $"..." strings get translated with gettext, using TEXTDOMAIN like bash does.
+// Otherwise they're treated as regular double quoted strings.
+func (t *Translator) Gettext(enabled bool) {
+	t.gettext = enabled
+}
+
 func (t *Translator) WriteTo(w io.Writer) (int64, error) {
 	return t.buf.WriteTo(w)
 }
@@ -46,8 +151,16 @@ func (t *Translator) File(f *syntax.File) (err error) {
 		}
 	}()
 
+	start := t.buf.Len()
+	defer t.writeHelpers(start)
+
 	for i, stmt := range f.Stmts {
-		t.stmt(stmt)
+		if execRedirect(stmt) {
+			t.execBlock(stmt, f.Stmts[i+1:])
+			t.nl()
+			break
+		}
+		t.bodyStmt(stmt)
 		t.nl()
 
 		isLast := i == len(f.Stmts)-1
@@ -70,6 +183,41 @@ func (t *Translator) File(f *syntax.File) (err error) {
 	return nil
 }
 
+// sideBuffer returns what f writes, instead of writing it to the output
+func (t *Translator) sideBuffer(f func()) string {
+	oldBuf := t.buf
+	newBuf := &bytes.Buffer{}
+	t.buf = newBuf
+	defer func() {
+		t.buf = oldBuf
+	}()
+	f()
+	return newBuf.String()
+}
+
+// uniqueName returns a new name for a generated variable or function
+func (t *Translator) uniqueName(prefix string) string {
+	t.names++
+	return fmt.Sprintf("%s_%d", prefix, t.names)
+}
+
+// writeHelpers inserts the definitions of the used helper functions at the given offset of the output
+func (t *Translator) writeHelpers(offset int) {
+	if len(t.helpers) == 0 {
+		return
+	}
+	out := &bytes.Buffer{}
+	out.Write(t.buf.Bytes()[:offset])
+	for _, name := range t.helpers {
+		out.WriteString(helperFuncs[name])
+		out.WriteString("\n")
+	}
+	out.Write(t.buf.Bytes()[offset:])
+	t.buf = out
+	t.helpers = nil
+	t.helpersUsed = map[string]bool{}
+}
+
 func (t *Translator) stmt(s *syntax.Stmt) {
 	if s.Background || s.Coprocess {
 		unsupported(s)
@@ -79,31 +227,48 @@ func (t *Translator) stmt(s *syntax.Stmt) {
 		t.comment(&comment)
 	}
 
+	if execRedirect(s) {
+		// body handles this, by redirecting the statements that follow
+		unsupportedf(s, "exec can only redirect the file descriptors of the shell on its own line")
+	}
+
 	if s.Negated {
 		t.str("! ")
 	}
+	if t.mapfile(s) {
+		return
+	}
 	t.command(s.Cmd)
 	for _, r := range s.Redirs {
 		t.str(" ")
+		t.redirect(s, r)
+	}
+}
 
-		if r.N != nil {
-			t.str(r.N.Value)
-		}
-		switch r.Op {
-		case syntax.RdrInOut, syntax.RdrIn, syntax.RdrOut, syntax.AppOut, syntax.DplIn, syntax.DplOut:
-			t.str(r.Op.String())
-			t.word(r.Word, false)
-		case syntax.Hdoc:
-			t.str("<(echo ")
-			t.word(r.Hdoc, true)
-			t.str("| psub)")
-		case syntax.WordHdoc:
-			t.str("<(echo ")
-			t.word(r.Word, true)
-			t.str("| psub)")
-		default:
-			unsupported(s)
+func (t *Translator) redirect(s *syntax.Stmt, r *syntax.Redirect) {
+	if r.N != nil {
+		t.str(r.N.Value)
+	}
+	switch r.Op {
+	case syntax.RdrInOut, syntax.RdrIn, syntax.RdrOut, syntax.AppOut, syntax.DplIn, syntax.DplOut:
+		t.str(r.Op.String())
+		t.word(r.Word, false)
+	case syntax.Hdoc:
+		q := quoteHeredoc
+		if _, ok := lit(r.Word); !ok || strings.Contains(r.Word.Lit(), `\`) {
+			q = quoteRaw
 		}
+		restore := t.withQuote(q)
+		t.str("<(echo ")
+		t.word(r.Hdoc, true)
+		t.str("| psub)")
+		restore()
+	case syntax.WordHdoc:
+		t.str("<(echo ")
+		t.word(r.Word, true)
+		t.str("| psub)")
+	default:
+		unsupported(s)
 	}
 }
 
@@ -144,17 +309,62 @@ func (t *Translator) arithmExpr(e syntax.ArithmExpr, returnValue arithmReturn) {
 			case arithmReturnValue:
 				t.str("; and echo 1; or echo 0)")
 			}
+		case syntax.Add, syntax.Sub, syntax.Mul, syntax.Quo, syntax.Rem, syntax.Pow:
+			switch returnValue {
+			case arithmReturnStatus:
+				t.str("test ")
+			}
+			op := e.Op.String()
+			if e.Op == syntax.Pow {
+				op = "^"
+			}
+			// bash only knows integers, so we ask math to truncate
+			t.str("(math -s0 ")
+			t.arithmExpr(e.X, arithmReturnValue)
+			t.printf(" '%s' ", op)
+			t.arithmExpr(e.Y, arithmReturnValue)
+			t.str(")")
+			switch returnValue {
+			case arithmReturnStatus:
+				t.str(" != 0")
+			}
 		default:
 			unsupported(e)
 		}
 	case *syntax.UnaryArithm:
-		unsupported(e)
+		switch e.Op {
+		case syntax.Plus:
+			t.arithmExpr(e.X, returnValue)
+		case syntax.Minus:
+			switch returnValue {
+			case arithmReturnStatus:
+				t.str("test ")
+			}
+			t.str("(math -s0 0 - ")
+			t.arithmExpr(e.X, arithmReturnValue)
+			t.str(")")
+			switch returnValue {
+			case arithmReturnStatus:
+				t.str(" != 0")
+			}
+		default:
+			unsupported(e)
+		}
 	case *syntax.ParenArithm:
-		unsupported(e)
+		t.arithmExpr(e.X, returnValue)
 	case *syntax.Word:
 		l, ok := lit(e)
 		if !ok {
-			unsupported(e)
+			switch returnValue {
+			case arithmReturnStatus:
+				t.str("test ")
+			}
+			t.word(e, true)
+			switch returnValue {
+			case arithmReturnStatus:
+				t.str(" != 0")
+			}
+			return
 		}
 
 		switch returnValue {
@@ -165,7 +375,7 @@ func (t *Translator) arithmExpr(e syntax.ArithmExpr, returnValue arithmReturn) {
 			if expr, ok := literalVariables[l]; ok {
 				t.str(expr)
 			} else {
-				t.printf(`"$%s"`, l)
+				t.printf(`"$%s"`, t.varName(l))
 			}
 		} else {
 			t.str(l)
@@ -210,7 +420,9 @@ func (t *Translator) command(c syntax.Command) {
 				t.str(" in")
 				for _, w := range l.Items {
 					t.str(" ")
-					t.word(w, false)
+					if !t.glob(w, true) {
+						t.arg(w)
+					}
 				}
 			} else {
 				t.str(" in $argv")
@@ -225,11 +437,7 @@ func (t *Translator) command(c syntax.Command) {
 		t.outdent()
 		t.str("end")
 	case *syntax.FuncDecl:
-		t.printf("function %s", c.Name.Value)
-		t.indent()
-		t.stmt(c.Body)
-		t.outdent()
-		t.str("end")
+		t.funcDecl(c)
 	case *syntax.IfClause:
 		t.ifClause(c, false)
 	case *syntax.LetClause:
@@ -245,6 +453,9 @@ func (t *Translator) command(c syntax.Command) {
 		t.str("time ")
 		t.stmt(c.Stmt)
 	case *syntax.WhileClause:
+		if t.getoptsLoop(c) {
+			return
+		}
 		t.str("while ")
 		if c.Until {
 			t.str("not ")
@@ -259,6 +470,53 @@ func (t *Translator) command(c syntax.Command) {
 	}
 }
 
+func (t *Translator) funcDecl(c *syntax.FuncDecl) {
+	t.printf("function %s", c.Name.Value)
+	if hasNameref(c.Body) {
+		// Namerefs usually point at variables of the caller, so the function needs to see them
+		t.str(" -S")
+	}
+	t.indent()
+	t.functionScope(func() {
+		t.returnTrap = setsReturnTrap(c.Body)
+		t.stmt(c.Body)
+		if t.returnTrap {
+			t.nl()
+			t.str(t.helper("__babelfish_return_trap"))
+		}
+	})
+	t.outdent()
+	t.str("end")
+}
+
+// functionScope runs f with the state for translating a function body.
+// Attributes and namerefs declared inside the function don't leak out of it.
+func (t *Translator) functionScope(f func()) {
+	oldAttrs := t.varAttrs
+	oldNamerefs := t.namerefs
+	oldLocals := t.locals
+	oldInFunction := t.inFunction
+	oldReturnTrap := t.returnTrap
+	oldIFS, oldIFSKnown := t.ifs, t.ifsKnown
+	t.varAttrs = make(map[string]varAttr, len(oldAttrs))
+	for name, attr := range oldAttrs {
+		t.varAttrs[name] = attr
+	}
+	t.namerefs = map[string]bool{}
+	t.locals = map[string]bool{}
+	t.inFunction = true
+	t.returnTrap = false
+	defer func() {
+		t.varAttrs = oldAttrs
+		t.namerefs = oldNamerefs
+		t.locals = oldLocals
+		t.inFunction = oldInFunction
+		t.returnTrap = oldReturnTrap
+		t.ifs, t.ifsKnown = oldIFS, oldIFSKnown
+	}()
+	f()
+}
+
 func (t *Translator) caseClause(c *syntax.CaseClause) {
 	t.str("switch ")
 	t.word(c.Word, true)
@@ -270,7 +528,7 @@ func (t *Translator) caseClause(c *syntax.CaseClause) {
 		t.str("case")
 		for _, pat := range item.Patterns {
 			t.str(" ")
-			t.word(pat, true)
+			t.patternWord(pat)
 		}
 		t.indent()
 		t.body(item.Stmts...)
@@ -280,8 +538,43 @@ func (t *Translator) caseClause(c *syntax.CaseClause) {
 }
 
 func (t *Translator) testClause(c *syntax.TestClause) {
+	t.testCmd(c.X)
+}
+
+// testCmd writes the command for a test expression.
+// Pattern matches need string match instead of test, which is why && and || are split here.
+func (t *Translator) testCmd(e syntax.TestExpr) {
+	if b, ok := e.(*syntax.BinaryTest); ok {
+		switch b.Op {
+		case syntax.AndTest, syntax.OrTest:
+			t.testCmd(b.X)
+			t.printf(" %s ", b.Op)
+			t.testCmd(b.Y)
+			return
+		case syntax.TsMatch, syntax.TsNoMatch:
+			if pat, ok := b.Y.(*syntax.Word); ok && hasGlob(pat) {
+				if b.Op == syntax.TsNoMatch {
+					t.str("not ")
+				}
+				t.str("string match -q -- ")
+				t.patternWord(pat)
+				t.str(" ")
+				t.testExpr(b.X)
+				return
+			}
+		}
+	}
 	t.str("test ")
-	t.testExpr(c.X)
+	t.testExpr(e)
+}
+
+// patternWord writes a glob pattern, which keeps the wildcards that bash matches literally escaped
+func (t *Translator) patternWord(w *syntax.Word) {
+	t.pattern = true
+	defer func() {
+		t.pattern = false
+	}()
+	t.word(w, true)
 }
 
 func (t *Translator) testExpr(e syntax.TestExpr) {
@@ -386,12 +679,266 @@ func (t *Translator) stmts(s ...*syntax.Stmt) {
 }
 
 func (t *Translator) body(s ...*syntax.Stmt) {
-	for i, s := range s {
+	for i, stmt := range s {
 		if i > 0 {
 			t.nl()
 		}
+		if execRedirect(stmt) {
+			t.execBlock(stmt, s[i+1:])
+			return
+		}
+		t.bodyStmt(stmt)
+	}
+}
+
+// execRedirect returns whether the statement is an exec without a command, which redirects the file descriptors of the shell itself
+func execRedirect(s *syntax.Stmt) bool {
+	c, ok := s.Cmd.(*syntax.CallExpr)
+	if !ok || len(c.Args) != 1 || len(s.Redirs) == 0 {
+		return false
+	}
+	l, _ := lit(c.Args[0])
+	return l == "exec"
+}
+
+// execBlock emulates exec redirecting the file descriptors of the shell.
+// Fish can't do that, so the statements that follow are redirected as a block instead.
+func (t *Translator) execBlock(s *syntax.Stmt, rest []*syntax.Stmt) {
+	for _, comment := range s.Comments {
+		t.comment(&comment)
+	}
+	outerSetup := t.setup
+	t.setup = nil
+	redirs := t.sideBuffer(func() {
+		for _, r := range s.Redirs {
+			if r.N != nil && strings.HasPrefix(r.N.Value, "{") {
+				unsupportedf(r, "exec can't allocate a file descriptor")
+			}
+			t.str(" ")
+			t.redirect(s, r)
+		}
+	})
+	for _, setup := range t.setup {
+		t.str(setup)
+	}
+	t.setup = outerSetup
+	t.str("begin")
+	t.indent()
+	if len(rest) == 0 {
+		t.str("true")
+	} else {
+		t.body(rest...)
+	}
+	t.outdent()
+	t.str("end")
+	t.str(redirs)
+}
+
+// bodyStmt translates a statement that stands on its own in a list of commands.
+// This is where set -e and set -u apply, and where setup for the statement can be placed.
+func (t *Translator) bodyStmt(s *syntax.Stmt) {
+	outerSetup := t.setup
+	t.setup = nil
+	stmt := t.sideBuffer(func() {
+		t.plainBodyStmt(s)
+	})
+	for _, setup := range t.setup {
+		t.str(setup)
+	}
+	t.setup = outerSetup
+	t.str(stmt)
+}
+
+func (t *Translator) plainBodyStmt(s *syntax.Stmt) {
+	errexit := t.errexit
+	if t.nounset {
+		for _, comment := range s.Comments {
+			t.comment(&comment)
+		}
+		noComments := *s
+		noComments.Comments = nil
+		s = &noComments
+		t.unboundGuards(s)
+	}
+
+	bc, ok := s.Cmd.(*syntax.BinaryCmd)
+	if errexit && ok && !s.Negated && len(s.Redirs) == 0 && (bc.Op == syntax.AndStmt || bc.Op == syntax.OrStmt) && errexitApplies(bc.Y) {
+		// Only the last command of a && or || list makes bash exit
+		for _, comment := range s.Comments {
+			t.comment(&comment)
+		}
+		t.stmt(bc.X)
+		t.printf(" %s begin; ", bc.Op)
+		t.stmt(bc.Y)
+		t.printf("; or %s $status; end", t.exitCmd())
+		return
+	}
+
+	if errexit && assignsCmdSubst(s) {
+		// The status of an assignment is the status of its last command substitution,
+		// which string collect would hide
+		t.substStatus = true
 		t.stmt(s)
+		t.substStatus = false
+		t.printf("; test $__babelfish_status -eq 0; or %s $__babelfish_status", t.exitCmd())
+		return
+	}
+
+	t.stmt(s)
+	if errexit && errexitApplies(s) {
+		t.printf("; or %s $status", t.exitCmd())
+	}
+}
+
+// exitCmd is the command that stops the script, which is return when in a function.
+// The status then propagates to the caller, which will exit in turn.
+func (t *Translator) exitCmd() string {
+	if t.inFunction {
+		return "return"
+	}
+	return "exit"
+}
+
+// errexitApplies returns whether bash would exit on failure of the statement with set -e
+func errexitApplies(s *syntax.Stmt) bool {
+	if s.Negated {
+		return false
+	}
+	switch c := s.Cmd.(type) {
+	case *syntax.CallExpr:
+		if len(c.Args) == 0 {
+			return false
+		}
+		switch l, _ := lit(c.Args[0]); l {
+		case "return", "exit", "break", "continue", "true", ":", "set", "shift", "trap":
+			return false
+		}
+		return true
+	case *syntax.BinaryCmd:
+		return c.Op == syntax.Pipe
+	case *syntax.ArithmCmd, *syntax.TestClause, *syntax.Subshell, *syntax.TimeClause:
+		return true
+	}
+	return false
+}
+
+// assignsCmdSubst returns whether the statement only assigns variables, using a command substitution
+func assignsCmdSubst(s *syntax.Stmt) bool {
+	c, ok := s.Cmd.(*syntax.CallExpr)
+	if !ok || s.Negated || len(c.Args) > 0 {
+		return false
+	}
+	for _, a := range c.Assigns {
+		var words []*syntax.Word
+		if a.Value != nil {
+			words = append(words, a.Value)
+		}
+		if a.Array != nil {
+			for _, el := range a.Array.Elems {
+				words = append(words, el.Value)
+			}
+		}
+		for _, w := range words {
+			for _, p := range flattenWord(w, false) {
+				if _, ok := p.part.(*syntax.CmdSubst); ok {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// unboundGuards emulates set -u by checking that the variables expanded by a statement are set.
+// Only the parts of the statement that aren't a body of their own are checked,
+// the bodies get their own guards.
+func (t *Translator) unboundGuards(s *syntax.Stmt) {
+	var names []string
+	seen := map[string]bool{}
+	var visit func(node syntax.Node) bool
+	visit = func(node syntax.Node) bool {
+		switch n := node.(type) {
+		case *syntax.FuncDecl, *syntax.Block:
+			return false
+		case *syntax.IfClause:
+			for el := n; el != nil; el = el.Else {
+				for _, s := range el.Cond {
+					syntax.Walk(s, visit)
+				}
+			}
+			return false
+		case *syntax.WhileClause:
+			for _, s := range n.Cond {
+				syntax.Walk(s, visit)
+			}
+			return false
+		case *syntax.ForClause:
+			syntax.Walk(n.Loop, visit)
+			return false
+		case *syntax.CaseClause:
+			syntax.Walk(n.Word, visit)
+			return false
+		case *syntax.ParamExp:
+			name, ok := t.unboundName(n)
+			if ok && !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+		return true
+	}
+	syntax.Walk(s, visit)
+
+	for _, name := range names {
+		display := strings.TrimPrefix(name, "argv")
+		if display == name {
+			display = strings.TrimPrefix(name, "$")
+		} else {
+			display = strings.Trim(display, "[]")
+		}
+		t.printf("set -q %s; or begin; echo %s >&2; %s 1; end", name, "'"+display+": unbound variable'", t.exitCmd())
+		t.nl()
+	}
+}
+
+// unboundName returns the variable that set -u would complain about for a parameter expansion
+func (t *Translator) unboundName(p *syntax.ParamExp) (string, bool) {
+	if p.Excl || p.Names != 0 || p.Param == nil {
+		return "", false
+	}
+	if p.Exp != nil {
+		switch p.Exp.Op {
+		case syntax.AlternateUnset, syntax.AlternateUnsetOrNull,
+			syntax.DefaultUnset, syntax.DefaultUnsetOrNull,
+			syntax.AssignUnset, syntax.AssignUnsetOrNull,
+			syntax.ErrorUnset, syntax.ErrorUnsetOrNull:
+			return "", false
+		}
+	}
+	if _, ok := t.listParam(p); ok {
+		// Bash 4.4 and later expand an unset array to nothing
+		return "", false
+	}
+	if word, ok := p.Index.(*syntax.Word); ok && word.Lit() == "*" && !p.Length {
+		return "", false
+	}
+	param := p.Param.Value
+	if argvRe.MatchString(param) {
+		if param == "0" {
+			return "", false
+		}
+		return "argv[" + param + "]", true
 	}
+	if _, ok := specialVariables[param]; ok {
+		return "", false
+	}
+	if _, ok := literalVariables[param]; ok {
+		return "", false
+	}
+	if !syntax.ValidName(param) {
+		return "", false
+	}
+	return t.varName(param), true
 }
 
 func (t *Translator) binaryCmd(c *syntax.BinaryCmd) {
@@ -407,6 +954,17 @@ func (t *Translator) binaryCmd(c *syntax.BinaryCmd) {
 		t.stmt(c.Y)
 		return
 	case syntax.Pipe:
+		if t.pipefail && !t.inPipe {
+			// The status of the pipeline is the last non-zero status of its commands
+			t.inPipe = true
+			defer func() { t.inPipe = false }()
+			t.str("begin; ")
+			t.stmt(c.X)
+			t.str(" | ")
+			t.stmt(c.Y)
+			t.printf("; %s $pipestatus; end", t.helper("__babelfish_pipefail"))
+			return
+		}
 		t.stmt(c.X)
 		t.str(" | ")
 		t.stmt(c.Y)
@@ -417,28 +975,165 @@ func (t *Translator) binaryCmd(c *syntax.BinaryCmd) {
 }
 
 func (t *Translator) assign(prefix string, a *syntax.Assign) {
+	if a.Name.Value == "IFS" {
+		t.trackIFS(a)
+	}
+	if a.Index != nil {
+		t.indexAssign(prefix, a)
+		return
+	}
+	if a.Append && a.Value != nil && t.varAttrs[a.Name.Value]&attrInteger != 0 {
+		// Bash adds to integer variables instead of appending
+		t.printf("set%s %s ", prefix, t.varName(a.Name.Value))
+		name := &syntax.Word{Parts: []syntax.WordPart{&syntax.Lit{Value: a.Name.Value}}}
+		t.arithmExpr(&syntax.BinaryArithm{Op: syntax.Add, X: name, Y: parseArithm(a.Value)}, arithmReturnValue)
+		return
+	}
 	if a.Append {
 		prefix += " -a"
 	}
 	switch {
 	case a.Naked:
-		t.printf("set%s %s ", prefix, a.Name.Value)
-		t.printf("$%s", a.Name.Value)
+		t.printf("set%s %s ", prefix, t.varName(a.Name.Value))
+		t.printf("$%s", t.varName(a.Name.Value))
 	case a.Array != nil:
-		t.printf("set%s %s", prefix, a.Name.Value)
+		t.printf("set%s %s", prefix, t.varName(a.Name.Value))
 		for _, el := range a.Array.Elems {
 			if el.Index != nil || el.Value == nil {
 				unsupported(a)
 			}
 			t.str(" ")
-			t.word(el.Value, false)
+			if !t.glob(el.Value, true) {
+				t.word(el.Value, false)
+			}
 		}
 	case a.Value != nil:
-		t.printf("set%s %s ", prefix, a.Name.Value)
-		t.word(a.Value, true)
-	case a.Index != nil:
+		t.printf("set%s %s ", prefix, t.varName(a.Name.Value))
+		t.assignValue(a.Name.Value, a.Value)
+	}
+}
+
+// indexAssign translates the assignment of a single array element, like a[1]=x.
+// Through a nameref, the quotes keep fish from indexing the variable that holds the name.
+func (t *Translator) indexAssign(prefix string, a *syntax.Assign) {
+	i, ok := fishIndex(a.Index)
+	if !ok || a.Append || a.Value == nil {
 		unsupported(a)
 	}
+	name := a.Name.Value
+	if t.namerefs[name] {
+		t.printf(`set%s "$%s"[%d] `, prefix, name, i)
+	} else {
+		t.printf("set%s %s[%d] ", prefix, name, i)
+	}
+	t.assignValue(name, a.Value)
+}
+
+// fishIndex returns the fish index for a static bash array index.
+// Bash counts from 0, fish from 1. Negative indices count from the end in both.
+func fishIndex(index syntax.ArithmExpr) (int, bool) {
+	negative := false
+	if u, ok := index.(*syntax.UnaryArithm); ok && u.Op == syntax.Minus && !u.Post {
+		negative = true
+		index = u.X
+	}
+	word, ok := index.(*syntax.Word)
+	if !ok {
+		return 0, false
+	}
+	i, err := strconv.Atoi(word.Lit())
+	if err != nil || i < 0 || negative && i == 0 {
+		return 0, false
+	}
+	if negative {
+		return -i, true
+	}
+	return i + 1, true
+}
+
+// trackIFS keeps track of the value of IFS, so word splitting can be done with the right separators
+func (t *Translator) trackIFS(a *syntax.Assign) {
+	t.ifsKnown = false
+	if a.Append || a.Index != nil || a.Array != nil {
+		return
+	}
+	if a.Value == nil {
+		if !a.Naked {
+			t.ifs, t.ifsKnown = "", true
+		}
+		return
+	}
+	t.ifs, t.ifsKnown = staticWord(a.Value)
+}
+
+// varName returns the fish expression for the name of a variable, which is the name itself unless it's a nameref
+func (t *Translator) varName(name string) string {
+	if t.namerefs[name] {
+		return "$" + name
+	}
+	return name
+}
+
+// assignValue writes the value of an assignment, applying the attributes of the variable
+func (t *Translator) assignValue(name string, w *syntax.Word) {
+	attr := t.varAttrs[name]
+	switch {
+	case attr&attrInteger != 0:
+		t.arithmWord(w)
+	case attr&attrLower != 0:
+		t.str("(string lower -- ")
+		t.assignWord(w)
+		t.str(")")
+	case attr&attrUpper != 0:
+		t.str("(string upper -- ")
+		t.assignWord(w)
+		t.str(")")
+	default:
+		t.assignWord(w)
+	}
+}
+
+// arithmWord evaluates a word as an arithmetic expression, like bash does for integer variables
+func (t *Translator) arithmWord(w *syntax.Word) {
+	t.arithmExpr(parseArithm(w), arithmReturnValue)
+}
+
+// parseArithm parses the value of a word as an arithmetic expression
+func parseArithm(w *syntax.Word) syntax.ArithmExpr {
+	var src bytes.Buffer
+	if err := syntax.NewPrinter().Print(&src, w); err != nil {
+		unsupported(w)
+	}
+	expr, err := syntax.NewParser(syntax.Variant(syntax.LangBash)).Arithmetic(&src)
+	if err != nil || expr == nil {
+		unsupported(w)
+	}
+	return expr
+}
+
+// readonlyGuard emulates a readonly variable with an event handler that restores the value on every change
+func (t *Translator) readonlyGuard(scope string, name string) {
+	guard := "__babelfish_readonly_" + name
+	t.nl()
+	t.printf("set%s %s $%s", scope, guard, name)
+	t.nl()
+	// -S so the handler can see (and restore) local variables
+	t.printf("function %s -S --on-variable %s", guard, name)
+	t.indent()
+	if scope == " -l" {
+		// The handler outlives the function, when the saved value is gone and the variable is no longer readonly
+		t.printf(`if set -q %s; and test "$%s" != "$%s"`, guard, name, guard)
+	} else {
+		t.printf(`if test "$%s" != "$%s"`, name, guard)
+	}
+	t.indent()
+	t.printf("set %s $%s", name, guard)
+	t.nl()
+	t.printf("echo %s >&2", "'"+name+": readonly variable'")
+	t.outdent()
+	t.str("end")
+	t.outdent()
+	t.str("end")
 }
 
 func (t *Translator) callExpr(c *syntax.CallExpr) {
@@ -452,21 +1147,44 @@ func (t *Translator) callExpr(c *syntax.CallExpr) {
 		}
 	} else {
 		// call
+		first := c.Args[0]
+		l, _ := lit(first)
 		if len(c.Assigns) > 0 {
+			oldIFS, oldIFSKnown := t.ifs, t.ifsKnown
+			defer func() {
+				t.ifs, t.ifsKnown = oldIFS, oldIFSKnown
+			}()
 			for _, a := range c.Assigns {
+				if a.Name.Value == "IFS" {
+					t.trackIFS(a)
+					if l == "read" {
+						// read decides how to split itself
+						continue
+					}
+				}
+				if _, static := evalSource(c); l == "eval" && (t.babelFishLocation != "" || static) {
+					// eval makes the assignments itself
+					continue
+				}
 				t.printf("%s=", a.Name.Value)
 				if a.Value != nil {
-					t.word(a.Value, true)
+					t.assignWord(a.Value)
 				}
 				t.str(" ")
 			}
 		}
 
-		first := c.Args[0]
-		l, _ := lit(first)
 		switch l {
+		case "return":
+			if t.returnTrap {
+				// Fish can't run anything after return, so the trap runs right before it
+				t.printf("begin; %s; ", t.helper("__babelfish_return_trap"))
+				defer t.str("; end")
+			}
+			t.word(first, false)
 		case "shift":
-			t.str("set -e argv[1]")
+			t.shift(c)
+			return
 		case "unset":
 			isFirst := true
 			unsetFunc := false
@@ -487,6 +1205,13 @@ func (t *Translator) callExpr(c *syntax.CallExpr) {
 					t.str("functions -e ")
 				} else {
 					t.str("set -e ")
+					if aStr == "IFS" {
+						t.ifs, t.ifsKnown = defaultIFS, true
+					}
+				}
+				if t.namerefs[aStr] {
+					t.str(t.varName(aStr))
+					continue
 				}
 				t.word(a, false)
 			}
@@ -494,9 +1219,50 @@ func (t *Translator) callExpr(c *syntax.CallExpr) {
 		case "hash":
 			t.str("true")
 			return
+		case "caller":
+			// The stack trace of fish only has the lines of the translation, while LINENO has those of the bash script
+			t.warnf(c, "caller reports line numbers of the fish translation, not of the bash script")
+			t.str(t.helper("__babelfish_caller"))
+		case "trap":
+			t.trap(c)
+			return
+		case "shopt":
+			t.shopt(c)
+			return
+		case "getopts":
+			t.getopts(c)
+			return
+		case "read":
+			t.read(c)
+			return
+		case "printf":
+			t.printfCmd(c)
+			return
+		case "echo":
+			t.echo(c)
+			return
+		case "alias":
+			t.alias(c)
+			return
+		case "unalias":
+			t.unalias(c)
+			return
+		case "set":
+			if t.set(c) {
+				return
+			}
+			t.word(first, false)
+		case "eval":
+			if t.eval(c) {
+				return
+			}
+			t.word(first, false)
 		case "source", ".":
 			if len(c.Args) == 2 && t.babelFishLocation != "" {
-				t.str(t.babelFishLocation)
+				// The sourced file knows its own path, for $0 and BASH_SOURCE
+				t.babelfish()
+				t.str(" -source-path ")
+				t.word(c.Args[1], false)
 				t.str(" < ")
 				t.word(c.Args[1], false)
 				t.str(" | source")
@@ -509,47 +1275,653 @@ func (t *Translator) callExpr(c *syntax.CallExpr) {
 
 		for _, a := range c.Args[1:] {
 			t.str(" ")
-			t.word(a, false)
+			if !t.glob(a, false) {
+				t.arg(a)
+			}
 		}
 	}
 }
 
-func (t *Translator) declClause(c *syntax.DeclClause) {
-	var prefix string
-	if c.Variant != nil {
-		switch c.Variant.Value {
-		case "export":
-			prefix = " -gx"
-		case "local":
-			prefix = " -l"
+// glob translates a word containing a glob, returning false if it doesn't contain one.
+// Bash keeps a glob that matches nothing as it is, unless nullglob or failglob are set.
+// Fish removes it in for loops and set, which is given by nullglob, and refuses to run any other command.
+func (t *Translator) glob(w *syntax.Word, nullglob bool) bool {
+	if !hasGlob(w) {
+		return false
+	}
+	braced := *w
+	if syntax.SplitBraces(&braced) {
+		return false
+	}
+	if t.shopts["nocaseglob"] {
+		unsupportedf(w, "nocaseglob has no equivalent in fish")
+	}
+	if nullglob && (t.shopts["nullglob"] || t.shopts["failglob"]) || !nullglob && t.shopts["failglob"] {
+		t.word(w, false)
+		return true
+	}
+	t.str("(set -l __babelfish_glob ")
+	t.word(w, false)
+	if !t.shopts["nullglob"] {
+		t.str("; set -q __babelfish_glob[1]; or set __babelfish_glob ")
+		t.word(w, true)
+	}
+	t.str("; string join0 -- $__babelfish_glob | string split0)")
+	return true
+}
+
+// hasGlob returns whether a word contains an unquoted * or ?
+func hasGlob(w *syntax.Word) bool {
+	for _, part := range w.Parts {
+		lit, ok := part.(*syntax.Lit)
+		if !ok {
+			continue
+		}
+		for i := 0; i < len(lit.Value); i++ {
+			switch lit.Value[i] {
+			case '\\':
+				i++
+			case '*', '?':
+				return true
+			}
+		}
+	}
+	return false
+}
+
+const defaultIFS = " \t\n"
+
+// arg translates a word that's an argument to a command, or an item in a for loop.
+// Unquoted expansions in these are split on IFS by bash, while fish doesn't split variables at all,
+// and only splits command substitutions on newlines.
+func (t *Translator) arg(w *syntax.Word) {
+	if t.noWordSplitting || len(w.Parts) != 1 {
+		t.word(w, false)
+		return
+	}
+	if !t.ifsKnown {
+		t.dynamicSplit(w)
+		return
+	}
+
+	var seps []string
+	for _, r := range t.ifs {
+		switch r {
+		case '\n':
+			// Command substitutions already split on newlines, see below
+		case ' ':
+			seps = append(seps, "' '")
+		case '\t':
+			seps = append(seps, `\t`)
 		default:
-			unsupported(c)
+			seps = append(seps, "'"+stringReplacer.Replace(string(r))+"'")
+		}
+	}
+
+	// Without a newline in IFS, the newlines in the value have to be kept.
+	// Fish keeps the fields of string split when it writes the output of a command substitution,
+	// but only splits lines when reading from a pipe, so the splits are nested instead.
+	newline := strings.ContainsRune(t.ifs, '\n')
+	switch wp := w.Parts[0].(type) {
+	case *syntax.ParamExp:
+		if _, ok := t.listParam(wp); ok || !splittable(wp) {
+			break
+		}
+		if !newline {
+			if len(seps) == 0 {
+				break
+			}
+			t.nestedSplit(seps, func() {
+				t.paramExp(wp, false)
+			})
+			return
+		}
+		if len(seps) == 0 {
+			if !strings.ContainsRune(t.ifs, '\n') {
+				break
+			}
+			seps = append(seps, `\n`)
 		}
+		t.printf("(string split -n -- %s ", seps[0])
+		t.paramExp(wp, false)
+		for _, sep := range seps[1:] {
+			t.printf(" | string split -n -- %s", sep)
+		}
+		t.str(")")
+		return
+	case *syntax.CmdSubst:
+		if t.ifs == "" {
+			t.str("(")
+			t.stmts(wp.Stmts...)
+			t.str(" | string collect)")
+			return
+		}
+		if !newline {
+			t.nestedSplit(seps, func() {
+				t.collect(wp)
+			})
+			return
+		}
+		if len(seps) == 0 {
+			break
+		}
+		t.str("(")
+		if len(wp.Stmts) > 1 {
+			t.str("begin; ")
+			t.stmts(wp.Stmts...)
+			t.str("; end")
+		} else {
+			t.stmts(wp.Stmts...)
+		}
+		for _, sep := range seps {
+			t.printf(" | string split -n -- %s", sep)
+		}
+		t.str(")")
+		return
 	}
+	t.word(w, false)
+}
 
-	for i, a := range c.Args {
+// nestedSplit writes a split of the value on every separator, with the first separator innermost
+func (t *Translator) nestedSplit(seps []string, value func()) {
+	for i := len(seps) - 1; i >= 0; i-- {
+		t.printf("(string split -n -- %s ", seps[i])
+	}
+	value()
+	t.str(strings.Repeat(")", len(seps)))
+}
+
+// collect writes a command substitution whose output is a single argument, even when it's empty
+func (t *Translator) collect(c *syntax.CmdSubst) {
+	t.str("(")
+	if len(c.Stmts) > 1 {
+		t.str("begin; ")
+		t.stmts(c.Stmts...)
+		t.str("; end")
+	} else {
+		t.stmts(c.Stmts...)
+	}
+	t.str(" | string collect; or echo)")
+}
+
+// dynamicSplit splits an unquoted expansion on IFS when it runs, because its value isn't known statically
+func (t *Translator) dynamicSplit(w *syntax.Word) {
+	switch wp := w.Parts[0].(type) {
+	case *syntax.ParamExp:
+		if _, ok := t.listParam(wp); ok || !splittable(wp) {
+			break
+		}
+		t.printf("(%s ", t.helper("__babelfish_ifs_split"))
+		t.paramExp(wp, false)
+		t.str(" | string split0)")
+		return
+	case *syntax.CmdSubst:
+		t.printf("(%s ", t.helper("__babelfish_ifs_split"))
+		t.collect(wp)
+		t.str(" | string split0)")
+		return
+	}
+	t.word(w, false)
+}
+
+// splittable returns whether the expansion could contain characters from IFS
+func splittable(p *syntax.ParamExp) bool {
+	if p.Length || p.Names != 0 {
+		return false
+	}
+	switch p.Param.Value {
+	case "?", "$", "#", "!", "-", "0", "BASH_PID", "BASHPID", "UID", "EUID", "PPID", "SHLVL", "RANDOM", "SRANDOM",
+		"SECONDS", "EPOCHSECONDS", "EPOCHREALTIME", "HOSTTYPE", "OSTYPE", "MACHTYPE", "PIPESTATUS", "LINENO", "FUNCNAME",
+		"BASH_VERSION", "BASH_VERSINFO":
+		return false
+	}
+	return true
+}
+
+func (t *Translator) declClause(c *syntax.DeclClause) {
+	var (
+		scope    string
+		export   string
+		attrs    varAttr
+		clrAttrs varAttr
+	)
+	switch c.Variant.Value {
+	case "export":
+		scope = "g"
+		export = "x"
+	case "local":
+		scope = "l"
+	case "declare", "typeset":
+		// Like local when used in a function
+		if t.inFunction {
+			scope = "l"
+		}
+	case "readonly":
+		scope = "g"
+		attrs |= attrReadonly
+	default:
+		unsupported(c)
+	}
+
+	opts, args := declOptions(c)
+	nameref := false
+	for _, flags := range opts {
+		add := flags[0] == '-'
+		for _, f := range flags[1:] {
+			var attr, opposite varAttr
+			switch f {
+			case 'x':
+				if add {
+					export = "x"
+				} else {
+					export = "u"
+				}
+				continue
+			case 'n':
+				// export -n removes the export attribute
+				if c.Variant.Value == "export" {
+					export = "u"
+				} else {
+					nameref = add
+				}
+				continue
+			case 'g':
+				scope = "g"
+				continue
+			case 'a':
+				// Every fish variable is a list
+				continue
+			case 'r':
+				attr = attrReadonly
+			case 'i':
+				attr = attrInteger
+			case 'l':
+				attr = attrLower
+				opposite = attrUpper
+			case 'u':
+				attr = attrUpper
+				opposite = attrLower
+			default:
+				unsupported(c)
+			}
+			if add {
+				// Lowercase and uppercase replace each other
+				attrs |= attr
+				clrAttrs |= opposite
+			} else {
+				clrAttrs |= attr
+			}
+		}
+	}
+
+	prefix := ""
+	if scope != "" || export != "" {
+		prefix = " -" + scope + export
+	}
+
+	for i, a := range args {
 		if a.Name == nil {
 			unsupported(c)
 		}
 		if i > 0 {
 			t.str("; ")
 		}
+		name := a.Name.Value
+		if nameref {
+			t.namerefDecl(prefix, a)
+			continue
+		}
+		t.varAttrs[name] = (t.varAttrs[name] | attrs) &^ clrAttrs
+		if scope == "l" {
+			t.locals[name] = true
+		}
 		t.assign(prefix, a)
+		if attrs&attrReadonly != 0 {
+			guardScope := ""
+			if scope != "" {
+				guardScope = " -" + scope
+			}
+			t.readonlyGuard(guardScope, name)
+		}
+	}
+}
+
+// declOptions splits the arguments of a declaration into the option flags and the actual assignments
+func declOptions(c *syntax.DeclClause) (opts []string, args []*syntax.Assign) {
+	args = c.Args
+	for len(args) > 0 {
+		a := args[0]
+		if a.Name != nil {
+			break
+		}
+		flags, ok := lit(a.Value)
+		if !ok || len(flags) < 2 || (flags[0] != '-' && flags[0] != '+') {
+			unsupported(c)
+		}
+		opts = append(opts, flags)
+		args = args[1:]
 	}
+	return opts, args
+}
+
+func hasNameref(n syntax.Node) bool {
+	found := false
+	syntax.Walk(n, func(n syntax.Node) bool {
+		switch n := n.(type) {
+		case *syntax.DeclClause:
+			if n.Variant.Value == "export" {
+				return true
+			}
+			for _, a := range n.Args {
+				if a.Name != nil || a.Value == nil {
+					continue
+				}
+				if flags, ok := lit(a.Value); ok && strings.HasPrefix(flags, "-") && strings.Contains(flags, "n") {
+					found = true
+				}
+			}
+		case *syntax.FuncDecl:
+			// Nested functions are handled on their own
+			return false
+		}
+		return !found
+	})
+	return found
+}
+
+// namerefDecl declares a nameref, which stores the name of the target variable.
+// The target has to be known statically, as either a name or a simple parameter expansion.
+func (t *Translator) namerefDecl(prefix string, a *syntax.Assign) {
+	name := a.Name.Value
+	if a.Value == nil || a.Index != nil || a.Array != nil || a.Append {
+		unsupportedf(a, "nameref %s needs a target", name)
+	}
+	if l, ok := lit(a.Value); ok {
+		if !syntax.ValidName(l) {
+			unsupportedf(a, "nameref %s has invalid target %q", name, l)
+		}
+	} else if len(a.Value.Parts) != 1 || !simpleParamExp(a.Value.Parts[0]) {
+		unsupportedf(a, "nameref %s target can't be resolved statically", name)
+	}
+	t.printf("set%s %s ", prefix, name)
+	t.word(a.Value, true)
+	t.namerefs[name] = true
+}
+
+func simpleParamExp(wp syntax.WordPart) bool {
+	p, ok := wp.(*syntax.ParamExp)
+	if !ok {
+		return false
+	}
+	return !p.Excl && !p.Length && !p.Width && p.Index == nil && p.Slice == nil && p.Repl == nil && p.Names == 0 && p.Exp == nil
 }
 
 func (t *Translator) word(w *syntax.Word, mustQuote bool) {
+	assignment := t.assignment
+	t.assignment = false
 	if w == nil {
 		t.str(`''`)
 		return
 	}
 
+	if !mustQuote {
+		// SplitBraces modifies the word, so work on a copy
+		braced := *w
+		if syntax.SplitBraces(&braced) {
+			w = &braced
+		}
+	}
+
+	// Fish concatenates the parts of a word as a cartesian product,
+	// so every part of a concatenation needs to expand to exactly one element
+	defer t.withConcat(len(w.Parts) > 1 || len(flattenWord(w, mustQuote)) > 1)()
+
+	if t.listConcat(w, mustQuote) {
+		return
+	}
+
 	quote := mustQuote
-	for _, part := range w.Parts {
+	for i, part := range w.Parts {
+		if lit, ok := part.(*syntax.Lit); ok {
+			t.literal(lit, i == 0, i == len(w.Parts)-1, quote, assignment)
+			continue
+		}
 		t.wordPart(part, quote)
 	}
 }
 
+// assignWord writes the value of an assignment, where bash also expands a tilde after every colon
+func (t *Translator) assignWord(w *syntax.Word) {
+	t.assignment = true
+	t.word(w, true)
+}
+
+// literal writes a literal part of a word, translating the tilde prefixes bash would expand.
+// start and end are set when the literal is at the start or the end of the word.
+func (t *Translator) literal(l *syntax.Lit, start, end, quoted, assignment bool) {
+	s := l.Value
+	// Tildes aren't expanded in heredocs
+	tilde := start && t.quote != quoteHeredoc && t.quote != quoteRaw
+	for s != "" {
+		if tilde && s[0] == '~' {
+			if n := t.tildePrefix(l, s, end, start && !assignment, assignment); n > 0 {
+				s = s[n:]
+				start = false
+				continue
+			}
+		}
+		n := len(s)
+		if assignment {
+			n = tildeColon(s) + 1
+		}
+		if quoted {
+			t.escapedString(t.unescape(s[:n]))
+		} else {
+			t.unquotedLit(s[:n], start)
+		}
+		s = s[n:]
+		start = false
+		tilde = assignment
+	}
+}
+
+// tildeColon returns the index of the first unescaped colon in s that's followed by a tilde,
+// or len(s)-1 if there is none.
+func tildeColon(s string) int {
+	for i := 0; i < len(s)-1; i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case ':':
+			if s[i+1] == '~' {
+				return i
+			}
+		}
+	}
+	return len(s) - 1
+}
+
+var (
+	loginNameRe = regexp.MustCompile(`^[A-Za-z0-9._][A-Za-z0-9._-]*$`)
+	dirStackRe  = regexp.MustCompile(`^[+-]?[0-9]+$`)
+)
+
+// tildePrefix translates the tilde prefix at the start of s, returning its length.
+// It returns 0 if bash wouldn't expand it, because it's quoted or not a valid login name.
+// native is set when fish would expand a tilde at this position itself.
+func (t *Translator) tildePrefix(l *syntax.Lit, s string, end, native, assignment bool) int {
+	stop := "/"
+	if assignment {
+		stop = "/:"
+	}
+	n := strings.IndexAny(s, stop)
+	if n < 0 {
+		if !end {
+			// The prefix continues into a quoted part of the word
+			return 0
+		}
+		n = len(s)
+	}
+	name := s[1:n]
+	switch {
+	case strings.ContainsRune(name, '\\'):
+		return 0
+	case name == "" && native:
+		t.str("~")
+	case name == "":
+		t.str(`"$HOME"`)
+	case name == "+":
+		t.str(`"$PWD"`)
+	case name == "-":
+		t.str(`"$dirprev[-1]"`)
+	case dirStackRe.MatchString(name):
+		unsupportedf(l, "directory stack expansion ~%s", name)
+	case !loginNameRe.MatchString(name):
+		return 0
+	case native:
+		t.printf("~%s", name)
+	default:
+		t.printf("(echo ~%s)", name)
+	}
+	return n
+}
+
+// unquotedLit writes an unquoted bash literal, escaping what fish would interpret differently.
+// Glob characters are kept as they are, unless they were escaped in bash.
+// start is set when the literal is at the start of a word.
+func (t *Translator) unquotedLit(s string, start bool) {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		escaped := false
+		if c == '\\' && i+1 < len(s) {
+			i++
+			c = s[i]
+			escaped = true
+			if c == '\n' {
+				// Line continuation
+				continue
+			}
+		}
+		first := i == 0 || (escaped && i == 1)
+		switch c {
+		case '*', '?':
+			if escaped {
+				sb.WriteByte('\\')
+				break
+			}
+			if t.shopts["dotglob"] && (first && start || i > 0 && s[i-1] == '/') {
+				// Fish only matches hidden files when the pattern starts with a dot
+				sb.WriteString("{.,}")
+			}
+			if c == '*' && i+1 < len(s) && s[i+1] == '*' && !t.shopts["globstar"] {
+				// ** is the same as * without globstar, while fish always matches directories recursively
+				i++
+			}
+		case '\n':
+			sb.WriteString(`\n`)
+			continue
+		case '\t':
+			sb.WriteString(`\t`)
+			continue
+		case '$', '\\', '(', ')', '{', '}', '<', '>', '^', '&', '|', ';', '\'', '"', ' ':
+			sb.WriteByte('\\')
+		case '~':
+			// Tilde prefixes are translated by literal, any other tilde is literal
+			sb.WriteByte('\\')
+		case '%', '#':
+			if start && first {
+				sb.WriteByte('\\')
+			}
+		case '[':
+			// Would be an index on a preceding expansion
+			if !start && first {
+				sb.WriteByte('\\')
+			}
+		case ',':
+			if t.inBrace {
+				sb.WriteByte('\\')
+			}
+		}
+		sb.WriteByte(c)
+	}
+	t.str(sb.String())
+}
+
+// quotedPart is a part of a word, with the quoting it has in bash
+type quotedPart struct {
+	part   syntax.WordPart
+	quoted bool
+}
+
+func flattenWord(w *syntax.Word, quoted bool) []quotedPart {
+	var parts []quotedPart
+	for _, part := range w.Parts {
+		if dq, ok := part.(*syntax.DblQuoted); ok && !dq.Dollar {
+			for _, part := range dq.Parts {
+				parts = append(parts, quotedPart{part, true})
+			}
+			continue
+		}
+		parts = append(parts, quotedPart{part, quoted})
+	}
+	return parts
+}
+
+// listParam returns the fish variable for expansions of a whole list, like $@ and ${arr[@]}
+func (t *Translator) listParam(wp syntax.WordPart) (string, bool) {
+	p, ok := wp.(*syntax.ParamExp)
+	if !ok || p.Excl || p.Length || p.Width || p.Slice != nil || p.Repl != nil || p.Names != 0 || p.Exp != nil {
+		return "", false
+	}
+	if p.Param.Value == "@" && p.Index == nil {
+		return "argv", true
+	}
+	if word, ok := p.Index.(*syntax.Word); ok && word.Lit() == "@" {
+		return t.varName(p.Param.Value), true
+	}
+	return "", false
+}
+
+// listConcat handles words where a list is concatenated with other parts, like "prefix$@suffix".
+// Bash adds the prefix to the first element and the suffix to the last element,
+// while fish would add them to every element.
+func (t *Translator) listConcat(w *syntax.Word, mustQuote bool) bool {
+	parts := flattenWord(w, mustQuote)
+	if len(parts) < 2 {
+		return false
+	}
+	list := -1
+	for i, p := range parts {
+		if _, ok := t.listParam(p.part); ok {
+			if list >= 0 {
+				unsupported(w)
+			}
+			list = i
+		}
+	}
+	if list < 0 {
+		return false
+	}
+
+	affix := func(parts []quotedPart) {
+		if len(parts) == 0 {
+			t.str("''")
+			return
+		}
+		for _, p := range parts {
+			t.wordPart(p.part, p.quoted)
+		}
+	}
+	name, _ := t.listParam(parts[list].part)
+	t.printf("(%s ", t.helper("__babelfish_concat_list"))
+	affix(parts[:list])
+	t.str(" ")
+	affix(parts[list+1:])
+	t.printf(" $%s | string split0)", name)
+	return true
+}
+
 // wordPart spits out a piece of a Word. The wordparts are placed next to each other, so that they are concatenated into one.
 // NOTE: This 'concatentation' is actually a cartesian product.
 // This means that every part *needs* to return a list with exactly one item.
@@ -563,42 +1935,76 @@ func (t *Translator) wordPart(wp syntax.WordPart, quoted bool) {
 	case *syntax.Lit:
 		s := wp.Value
 		if quoted {
-			s = unescape(s)
+			s = t.unescape(s)
 			t.escapedString(s)
 		} else {
-			t.str(s)
+			t.unquotedLit(s, false)
 		}
 	case *syntax.SglQuoted:
+		if wp.Dollar {
+			t.ansiCString(decodeANSIC(wp.Value))
+			return
+		}
+		if t.pattern {
+			t.escapedString(escapeWildcards(wp.Value))
+			return
+		}
 		t.escapedString(wp.Value)
 	case *syntax.DblQuoted:
+		if wp.Dollar && t.gettext {
+			if msgid, ok := staticWord(&syntax.Word{Parts: []syntax.WordPart{&syntax.DblQuoted{Parts: wp.Parts}}}); ok {
+				t.str(`(gettext -d "$TEXTDOMAIN" -- `)
+				t.escapedString(msgid)
+				t.str(" | string collect; or echo)")
+				return
+			}
+		}
 		if len(wp.Parts) == 0 {
 			t.str(`''`)
 		}
+		defer t.withQuote(quoteDouble)()
 		for _, part := range wp.Parts {
 			t.wordPart(part, true)
 		}
 	case *syntax.ParamExp:
+		if t.concat {
+			t.concatParamExp(wp, quoted)
+			return
+		}
 		t.paramExp(wp, quoted)
 	case *syntax.CmdSubst:
+		defer t.withQuote(quoteNone)()
 		// Need to ensure there's one element returned from the subst
+		single := quoted || t.concat
+		status := t.substStatus
+		t.substStatus = false
 		t.str("(")
+		if status {
+			t.str("begin; ")
+		}
 		t.stmts(wp.Stmts...)
-		if quoted {
+		if status {
+			t.str("; set -g __babelfish_status $status; end")
+		}
+		t.substStatus = status
+		if single {
 			t.str(" | string collect; or echo")
 		}
 		t.str(")")
 	case *syntax.ArithmExp:
 		t.arithmExpr(wp.X, arithmReturnValue)
 	case *syntax.ProcSubst:
-		t.str("(")
-		t.stmts(wp.Stmts...)
+		defer t.withQuote(quoteNone)()
 		switch wp.Op {
 		case syntax.CmdIn:
-			t.str(" | psub")
+			t.str("(")
+			t.stmts(wp.Stmts...)
+			t.str(" | psub)")
 		case syntax.CmdOut:
-			unsupported(wp)
+			t.outputProcSubst(wp)
 		}
-		t.str(")")
+	case *syntax.BraceExp:
+		t.braceExp(wp)
 	case *syntax.ExtGlob:
 		unsupported(wp)
 	default:
@@ -606,27 +2012,198 @@ func (t *Translator) wordPart(wp syntax.WordPart, quoted bool) {
 	}
 }
 
+// concatParamExp translates a parameter expansion that's concatenated with other parts.
+// Bash expands it to exactly one string, while an empty or unset fish variable would remove the whole word.
+func (t *Translator) concatParamExp(p *syntax.ParamExp, quoted bool) {
+	switch param := p.Param.Value; {
+	case argvRe.MatchString(param) && param != "0":
+		// An index that's out of range is an empty string when quoted
+		t.printf(`"$argv[%s]"`, param)
+	case param == "GROUPS":
+		t.str("(string join ' ' -- ")
+		t.paramExp(p, quoted)
+		t.str(" | string collect; or echo)")
+	case p.Exp != nil && (p.Exp.Op == syntax.AlternateUnset || p.Exp.Op == syntax.AlternateUnsetOrNull) && singleLine(p.Exp.Word):
+		t.paramExp(p, true)
+	case p.Repl != nil || p.Exp != nil:
+		// These are command substitutions, which split the result on newlines
+		t.str(`(string join \n -- `)
+		t.paramExp(p, true)
+		t.str(" | string collect; or echo)")
+	default:
+		t.paramExp(p, true)
+	}
+}
+
+// singleLine returns whether a word is known to expand to a string without newlines
+func singleLine(w *syntax.Word) bool {
+	s, ok := staticWord(w)
+	return ok && !strings.Contains(s, "\n")
+}
+
+// outputProcSubst translates >(cmd) into a FIFO, with the command reading from it in the background.
+// The command is started before the statement, because a command substitution would wait for it.
+func (t *Translator) outputProcSubst(p *syntax.ProcSubst) {
+	fifo := t.uniqueName("__babelfish_fifo")
+	t.setup = append(t.setup, t.sideBuffer(func() {
+		t.printf("set -l %s (%s)", fifo, t.helper("__babelfish_mkfifo"))
+		t.nl()
+		t.str("fish -c ")
+		t.capture(func() {
+			t.stmts(p.Stmts...)
+		})
+		t.printf(" <$%s &", fifo)
+		t.nl()
+	}))
+	t.printf("$%s", fifo)
+}
+
+// braceExp translates brace expansion. Fish supports {a,b} itself, but not sequences like {1..10}
+func (t *Translator) braceExp(b *syntax.BraceExp) {
+	if !b.Sequence {
+		oldInBrace := t.inBrace
+		t.inBrace = true
+		defer func() { t.inBrace = oldInBrace }()
+		t.str("{")
+		for i, elem := range b.Elems {
+			if i > 0 {
+				t.str(",")
+			}
+			for _, part := range elem.Parts {
+				t.wordPart(part, false)
+			}
+		}
+		t.str("}")
+		return
+	}
+
+	from, to := b.Elems[0].Lit(), b.Elems[1].Lit()
+	incr := 1
+	if len(b.Elems) > 2 {
+		incr, _ = strconv.Atoi(b.Elems[2].Lit())
+		if incr < 0 {
+			incr = -incr
+		}
+		if incr == 0 {
+			incr = 1
+		}
+	}
+
+	start, err := strconv.Atoi(from)
+	if err != nil {
+		// A sequence of letters, which we can spell out
+		var letters []string
+		for c := from[0]; ; {
+			letters = append(letters, string(c))
+			if from[0] <= to[0] {
+				if int(c)+incr > int(to[0]) {
+					break
+				}
+				c += byte(incr)
+			} else {
+				if int(c)-incr < int(to[0]) {
+					break
+				}
+				c -= byte(incr)
+			}
+		}
+		if len(letters) == 1 {
+			t.str(letters[0])
+			return
+		}
+		t.printf("{%s}", strings.Join(letters, ","))
+		return
+	}
+	end, _ := strconv.Atoi(to)
+	if start > end {
+		incr = -incr
+	}
+
+	t.str("(seq ")
+	width := len(from)
+	if len(to) > width {
+		width = len(to)
+	}
+	if zeroPadded(from) || zeroPadded(to) {
+		if len(from) == len(to) {
+			t.str("-w ")
+		} else {
+			t.printf("-f %%0%dg ", width)
+		}
+	}
+	if incr == 1 {
+		t.printf("%d %d)", start, end)
+	} else {
+		t.printf("%d %d %d)", start, incr, end)
+	}
+}
+
+func zeroPadded(n string) bool {
+	n = strings.TrimPrefix(n, "-")
+	return len(n) > 1 && n[0] == '0'
+}
+
 var specialVariables = map[string]string{
-	//"!": "%last", % variables are weird
-	"?":        "status",
-	"$":        "fish_pid",
-	"BASH_PID": "fish_pid",
-	"*":        `argv`, // always quote
-	"@":        "argv",
-	"HOSTNAME": "hostname",
+	"?":          "status",
+	"$":          "fish_pid",
+	"!":          "last_pid",
+	"BASH_PID":   "fish_pid",
+	"BASHPID":    "fish_pid",
+	"*":          `argv`, // always quote
+	"@":          "argv",
+	"HOSTNAME":   "hostname",
+	"OLDPWD":     "dirprev[-1]",
+	"PIPESTATUS": "pipestatus",
 }
 
 // http://tldp.org/LDP/abs/html/internalvariables.html
 var literalVariables = map[string]string{
-	"UID":    "(id -ru)",
-	"EUID":   "(id -u)",
-	"GROUPS": "(id -G | string split ' ')",
+	"UID":           "(id -ru)",
+	"EUID":          "(id -u)",
+	"GROUPS":        "(id -G | string split ' ')",
+	"#":             "(count $argv)",
+	"PPID":          "(ps -o ppid= -p $fish_pid | string trim)",
+	"RANDOM":        "(random 0 32767)",
+	"SRANDOM":       "(random 0 4294967295)",
+	"EPOCHSECONDS":  "(date +%s)",
+	"EPOCHREALTIME": epochRealtime,
+	"HOSTTYPE":      "(uname -m)",
+	"OSTYPE":        ostype,
+	"MACHTYPE":      machtype,
 }
 
+const (
+	// Only GNU date has %N, elsewhere the fraction is left at zero
+	epochRealtime = `(date +%s.%6N | string match -r '^\d+\.\d{6}$'; or echo (date +%s).000000)`
+	ostype        = "(uname -s | string lower | string replace linux linux-gnu)"
+	machtype      = "(uname -m)-unknown-" + ostype
+)
+
 var argvRe = regexp.MustCompile(`^[0-9]+$`)
 
 func (t *Translator) paramExp(p *syntax.ParamExp, quoted bool) {
 	param := p.Param.Value
+	switch param {
+	case "-":
+		t.shellFlags()
+		return
+	case "SECONDS":
+		t.printf("(%s)", t.helper("__babelfish_seconds"))
+		return
+	case "BASH_VERSION", "BASH_VERSINFO":
+		if t.bashVersionParam(p, quoted) {
+			return
+		}
+	case "BASH_SOURCE", "FUNCNAME":
+		t.callStackParam(p)
+		return
+	case "LINENO":
+		t.printf("%d", p.Pos().Line())
+		return
+	case "0":
+		t.scriptName()
+		return
+	}
 	if expr, ok := literalVariables[param]; ok {
 		t.str(expr)
 		return
@@ -637,11 +2214,9 @@ func (t *Translator) paramExp(p *syntax.ParamExp, quoted bool) {
 	}
 
 	if spec, ok := specialVariables[param]; ok {
-		// 🤷
-		if param == "*" {
-			quoted = true
-		}
 		param = spec
+	} else {
+		param = t.varName(param)
 	}
 	switch {
 	case p.Excl: // ${!a}
@@ -670,10 +2245,23 @@ func (t *Translator) paramExp(p *syntax.ParamExp, quoted bool) {
 				t.printf(`$%s`, param)
 				return
 			case "*":
-				t.printf(`"$%s"`, param)
+				t.joinList(param, quoted)
 				return
 			}
 		}
+		if i, ok := fishIndex(p.Index); ok {
+			index := fmt.Sprintf("[%d]", i)
+			if t.namerefs[p.Param.Value] {
+				// The first index applies to the variable holding the name, the second to the target
+				index = "[1]" + index
+			}
+			if quoted {
+				t.printf(`"$%s%s"`, param, index)
+			} else {
+				t.printf(`$%s%s`, param, index)
+			}
+			return
+		}
 		unsupported(p)
 	case p.Width: // ${%a}
 		unsupported(p)
@@ -684,9 +2272,12 @@ func (t *Translator) paramExp(p *syntax.ParamExp, quoted bool) {
 		if p.Repl.All {
 			t.str("--all ")
 		}
+		// Backslashes in the pattern escape any character, even inside double quotes
+		restore := t.withQuote(quoteNone)
 		t.word(p.Repl.Orig, true)
 		t.str(" ")
 		t.word(p.Repl.With, true)
+		restore()
 		t.printf(` "$%s")`, param)
 	case p.Names != 0: // ${!prefix*} or ${!prefix@}
 		unsupported(p)
@@ -721,7 +2312,6 @@ func (t *Translator) paramExp(p *syntax.ParamExp, quoted bool) {
 			if !ok {
 				unsupported(p)
 			}
-			pat = unescape(pat)
 			expr, err := pattern.Regexp(pat, mode)
 			if err != nil {
 				unsupported(p)
@@ -742,6 +2332,18 @@ func (t *Translator) paramExp(p *syntax.ParamExp, quoted bool) {
 		default:
 			unsupported(p)
 		}
+	case p.Param.Value == "@":
+		// "$@" keeps every element as is, which is what a fish list does anyway
+		t.printf(`$%s`, param)
+	case p.Param.Value == "*":
+		t.joinList(param, quoted)
+	case p.Param.Value == "PIPESTATUS":
+		// Without an index, an array expands to its first element
+		if quoted {
+			t.printf(`"$%s[1]"`, param)
+		} else {
+			t.printf(`$%s[1]`, param)
+		}
 	case p.Short:
 		fallthrough
 	default:
@@ -753,6 +2355,130 @@ func (t *Translator) paramExp(p *syntax.ParamExp, quoted bool) {
 	}
 }
 
+// shellFlags translates $-, the options bash is running with
+func (t *Translator) shellFlags() {
+	flags := func(interactive bool) string {
+		var sb strings.Builder
+		if t.errexit {
+			sb.WriteString("e")
+		}
+		sb.WriteString("h")
+		if interactive {
+			sb.WriteString("im")
+		}
+		if t.nounset {
+			sb.WriteString("u")
+		}
+		sb.WriteString("B")
+		if interactive {
+			sb.WriteString("Hs")
+		}
+		return sb.String()
+	}
+	t.printf("(status is-interactive; and echo %s; or echo %s)", flags(true), flags(false))
+}
+
+var bashVersionRe = regexp.MustCompile(`^(\d+)\.(\d+)\.(\d+)\((\d+)\)-(\w+)$`)
+
+// bashVersionParam translates $BASH_VERSION and $BASH_VERSINFO, returning false for expansions that aren't plain or indexed
+func (t *Translator) bashVersionParam(p *syntax.ParamExp, quoted bool) bool {
+	if p.Excl || p.Length || p.Width || p.Slice != nil || p.Repl != nil || p.Names != 0 || p.Exp != nil {
+		return false
+	}
+	if p.Param.Value == "BASH_VERSION" {
+		if p.Index != nil {
+			return false
+		}
+		t.escapedString(t.bashVersion)
+		return true
+	}
+
+	m := bashVersionRe.FindStringSubmatch(t.bashVersion)
+	if m == nil {
+		unsupportedf(p, "can't split bash version %q into BASH_VERSINFO", t.bashVersion)
+	}
+	versinfo := m[1:]
+	index := "0"
+	if w, ok := p.Index.(*syntax.Word); ok {
+		index = w.Lit()
+	} else if p.Index != nil {
+		return false
+	}
+	switch index {
+	case "*", "@":
+		if index == "*" && quoted {
+			t.escapedString(strings.Join(versinfo, " ") + " ")
+		} else {
+			for _, v := range versinfo {
+				t.escapedString(v)
+				t.str(" ")
+			}
+		}
+		t.str(machtype)
+	case "5":
+		t.str(machtype)
+	default:
+		i, err := strconv.Atoi(index)
+		if err != nil || i < 0 || i >= len(versinfo) {
+			return false
+		}
+		t.escapedString(versinfo[i])
+	}
+	return true
+}
+
+// scriptName translates $0, which depends on how the translation is run
+func (t *Translator) scriptName() {
+	switch {
+	case t.sourcePath != "":
+		t.escapedString(t.sourcePath)
+	case t.inFunction:
+		t.str("(status current-command)")
+	default:
+		t.str("(status filename)")
+	}
+}
+
+// callStackParam translates $BASH_SOURCE and $FUNCNAME, of which fish only knows the current element
+func (t *Translator) callStackParam(p *syntax.ParamExp) {
+	if p.Excl || p.Length || p.Width || p.Slice != nil || p.Repl != nil || p.Names != 0 || p.Exp != nil {
+		unsupported(p)
+	}
+	if w, ok := p.Index.(*syntax.Word); p.Index != nil && (!ok || w.Lit() != "0") {
+		unsupportedf(p, "only the first element of %s can be translated", p.Param.Value)
+	}
+	switch {
+	case p.Param.Value == "FUNCNAME" && !t.inFunction:
+		// FUNCNAME is unset outside of functions
+		t.str("''")
+	case p.Param.Value == "FUNCNAME":
+		t.str("(status current-function)")
+	case t.sourcePath != "":
+		t.escapedString(t.sourcePath)
+	default:
+		t.str("(status current-filename)")
+	}
+}
+
+// joinList expands a list like $* does, which joins the elements with IFS when quoted
+func (t *Translator) joinList(param string, quoted bool) {
+	if !quoted {
+		t.printf(`$%s`, param)
+		return
+	}
+	if !t.ifsKnown {
+		t.printf("(%s $%s | string collect; or echo)", t.helper("__babelfish_ifs_join"), param)
+		return
+	}
+	sep := ""
+	if t.ifs != "" {
+		sep = t.ifs[:1]
+	}
+	t.str("(string join -- ")
+	t.escapedString(sep)
+	t.printf(" $%s | string collect; or echo)", param)
+}
+
 var stringReplacer = strings.NewReplacer("\\", "\\\\", "'", "\\'")
 
 func (t *Translator) capture(f func()) {
@@ -772,6 +2498,139 @@ func (t *Translator) escapedString(literal string) {
 	t.str("'")
 }
 
+// ansiCString writes a string that may contain any bytes.
+// Printable characters are quoted, everything else is written as a fish escape sequence outside of the quotes.
+func (t *Translator) ansiCString(s string) {
+	if s == "" {
+		t.str("''")
+		return
+	}
+	var quoted strings.Builder
+	flush := func() {
+		if quoted.Len() > 0 {
+			t.escapedString(quoted.String())
+			quoted.Reset()
+		}
+	}
+	for len(s) > 0 {
+		r, size := utf8.DecodeRuneInString(s)
+		if r == utf8.RuneError && size <= 1 {
+			flush()
+			t.printf(`\X%02x`, s[0])
+			s = s[1:]
+			continue
+		}
+		s = s[size:]
+		if unicode.IsPrint(r) {
+			quoted.WriteRune(r)
+			continue
+		}
+		flush()
+		switch r {
+		case '\a':
+			t.str(`\a`)
+		case '\b':
+			t.str(`\b`)
+		case 0x1b:
+			t.str(`\e`)
+		case '\f':
+			t.str(`\f`)
+		case '\n':
+			t.str(`\n`)
+		case '\r':
+			t.str(`\r`)
+		case '\t':
+			t.str(`\t`)
+		case '\v':
+			t.str(`\v`)
+		default:
+			if r < 0x80 {
+				t.printf(`\x%02x`, r)
+			} else if r <= 0xffff {
+				t.printf(`\u%04x`, r)
+			} else {
+				t.printf(`\U%08x`, r)
+			}
+		}
+	}
+	flush()
+}
+
+// decodeANSIC interprets the escape sequences in the contents of a $'...' string
+func decodeANSIC(s string) string {
+	var buf bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c != '\\' || i+1 >= len(s) {
+			buf.WriteByte(c)
+			continue
+		}
+		i++
+		switch c = s[i]; c {
+		case 'a':
+			buf.WriteByte('\a')
+		case 'b':
+			buf.WriteByte('\b')
+		case 'e', 'E':
+			buf.WriteByte(0x1b)
+		case 'f':
+			buf.WriteByte('\f')
+		case 'n':
+			buf.WriteByte('\n')
+		case 'r':
+			buf.WriteByte('\r')
+		case 't':
+			buf.WriteByte('\t')
+		case 'v':
+			buf.WriteByte('\v')
+		case '\\', '\'', '"', '?':
+			buf.WriteByte(c)
+		case 'c':
+			// Control character
+			if i+1 < len(s) {
+				i++
+				buf.WriteByte(s[i] & 0x1f)
+			} else {
+				buf.WriteString(`\c`)
+			}
+		case '0', '1', '2', '3', '4', '5', '6', '7':
+			n, j := 0, i
+			for ; j < len(s) && j < i+3 && s[j] >= '0' && s[j] <= '7'; j++ {
+				n = n*8 + int(s[j]-'0')
+			}
+			buf.WriteByte(byte(n))
+			i = j - 1
+		case 'x', 'u', 'U':
+			max := map[byte]int{'x': 2, 'u': 4, 'U': 8}[c]
+			j := i + 1
+			for ; j < len(s) && j < i+1+max && isHex(s[j]); j++ {
+			}
+			if j == i+1 {
+				buf.WriteByte('\\')
+				buf.WriteByte(c)
+				continue
+			}
+			n, _ := strconv.ParseUint(s[i+1:j], 16, 32)
+			if c == 'x' {
+				buf.WriteByte(byte(n))
+			} else {
+				buf.WriteRune(rune(n))
+			}
+			i = j - 1
+		default:
+			buf.WriteByte('\\')
+			buf.WriteByte(c)
+		}
+	}
+	// bash strings end at a NUL byte
+	out, _, _ := strings.Cut(buf.String(), "\x00")
+	return out
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
 func (t *Translator) comment(c *syntax.Comment) {
 	t.printf("#%s", c.Text)
 	t.nl()
@@ -802,6 +2661,34 @@ func (t *Translator) nl() {
 	}
 }
 
+// staticWord returns the value of a word if it doesn't contain any expansions
+func staticWord(w *syntax.Word) (string, bool) {
+	var sb strings.Builder
+	for _, part := range w.Parts {
+		switch part := part.(type) {
+		case *syntax.Lit:
+			sb.WriteString(unescapeQuoted(part.Value, quoteNone))
+		case *syntax.SglQuoted:
+			if part.Dollar {
+				sb.WriteString(decodeANSIC(part.Value))
+				continue
+			}
+			sb.WriteString(part.Value)
+		case *syntax.DblQuoted:
+			for _, part := range part.Parts {
+				lit, ok := part.(*syntax.Lit)
+				if !ok {
+					return "", false
+				}
+				sb.WriteString(unescapeQuoted(lit.Value, quoteDouble))
+			}
+		default:
+			return "", false
+		}
+	}
+	return sb.String(), true
+}
+
 func lit(w *syntax.Word) (string, bool) {
 	// In the usual case, we'll have either a single part that's a literal,
 	// or one of the parts being a non-literal. Using strings.Join instead
@@ -818,19 +2705,104 @@ func lit(w *syntax.Word) (string, bool) {
 	return strings.Join(lits, ""), true
 }
 
-func unescape(s string) string {
+// quoteState is the quoting context of a piece of bash code, which decides what a backslash escapes
+type quoteState int
+
+const (
+	quoteNone quoteState = iota
+	quoteDouble
+	// quoteHeredoc is the body of a heredoc with an unquoted delimiter
+	quoteHeredoc
+	// quoteRaw is a heredoc with a quoted delimiter, where backslashes are literal
+	quoteRaw
+)
+
+// withQuote switches the quoting context, returning a function to restore the previous one
+func (t *Translator) withQuote(q quoteState) func() {
+	old := t.quote
+	t.quote = q
+	return func() {
+		t.quote = old
+	}
+}
+
+// withConcat sets whether parts are concatenated with others, returning a function to restore the previous state
+func (t *Translator) withConcat(concat bool) func() {
+	old := t.concat
+	t.concat = concat
+	return func() {
+		t.concat = old
+	}
+}
+
+// unescape removes the backslashes that escape characters in the current quoting context
+func (t *Translator) unescape(s string) string {
+	if t.pattern {
+		if t.quote == quoteNone {
+			return unescapePattern(s)
+		}
+		return escapeWildcards(unescapeQuoted(s, t.quote))
+	}
+	return unescapeQuoted(s, t.quote)
+}
+
+// wildcardEscaper escapes the characters that fish's wildcard matching gives a meaning
+var wildcardEscaper = strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`)
+
+// escapeWildcards makes quoted text from bash match literally in a fish pattern
+func escapeWildcards(s string) string {
+	return wildcardEscaper.Replace(s)
+}
+
+// unescapePattern removes the backslashes of an unquoted pattern, except the ones escaping a wildcard
+func unescapePattern(s string) string {
 	if !strings.Contains(s, `\`) {
 		return s
 	}
+	var buf bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+			switch s[i] {
+			case '*', '?', '\\':
+				buf.WriteByte('\\')
+			case '\n':
+				continue
+			}
+		}
+		buf.WriteByte(s[i])
+	}
+	return buf.String()
+}
+
+// unescapeQuoted removes the backslashes that bash would remove in the given quoting context.
+//
+// The parser already removes the backslashes that escape $, ` and \ for backquotes themselves,
+// so the literals inside of a backquoted command substitution follow the regular rules.
+func unescapeQuoted(s string, q quoteState) string {
+	if q == quoteRaw || !strings.Contains(s, `\`) {
+		return s
+	}
 
 	var buf bytes.Buffer
 	for i := 0; i < len(s); i++ {
 		b := s[i]
-		// TODO: this is taken from sh, but it's wrong. The special characters depend on the context.
-		// So I need a quote state variable in Translator
 		if b == '\\' && i+1 < len(s) {
-			switch s[i+1] {
-			case '/', '"', '\\', '$', '`': // special chars
+			next := s[i+1]
+			escaped := false
+			switch q {
+			case quoteNone:
+				escaped = true
+			case quoteDouble:
+				escaped = next == '$' || next == '`' || next == '"' || next == '\\' || next == '\n'
+			case quoteHeredoc:
+				escaped = next == '$' || next == '`' || next == '\\' || next == '\n'
+			}
+			if escaped {
+				i++
+				if next != '\n' {
+					buf.WriteByte(next)
+				}
 				continue
 			}
 		}
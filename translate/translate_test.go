@@ -24,9 +24,12 @@ func equal(t testing.TB, wanted, actual interface{}) {
 
 func TestParse(t *testing.T) {
 	tests := []struct {
-		name     string
-		in       string
-		expected string
+		name            string
+		in              string
+		expected        string
+		noWordSplitting bool
+		gettext         bool
+		sourcePath      string
 	}{
 		{
 			name:     "chruby.sh",
@@ -54,7 +57,7 @@ end
 		{
 			name: "recursive translation",
 			in:   `source /opt/source.sh`,
-			expected: `/bin/babelfish < /opt/source.sh | source
+			expected: `/bin/babelfish -source-path /opt/source.sh < /opt/source.sh | source
 `,
 		},
 		{
@@ -63,7 +66,7 @@ end
 export NIX_PATH="nixpkgs=/nix/var/nix/profiles/per-user/root/channels/nixos:nixos-config=/etc/nixos/configuration.nix"
 export NIX_PATH="$HOME/.nix-defexpr/channels${NIX_PATH:+:$NIX_PATH}"`,
 			expected: `set -gx NIX_PATH 'nixpkgs=/nix/var/nix/profiles/per-user/root/channels/nixos:nixos-config=/etc/nixos/configuration.nix'
-set -gx NIX_PATH "$HOME"'/.nix-defexpr/channels'(test -n "$NIX_PATH" && echo ':'"$NIX_PATH" || echo)
+set -gx NIX_PATH "$HOME"'/.nix-defexpr/channels'(string join \n -- (test -n "$NIX_PATH" && echo ':'"$NIX_PATH" || echo) | string collect; or echo)
 `,
 		},
 		{
@@ -76,7 +79,633 @@ set -gx NIX_PATH "$HOME"'/.nix-defexpr/channels'(test -n "$NIX_PATH" && echo ':'
 			in: `a=nixpkgs
 nix run $a#hello
 `, expected: `set a 'nixpkgs'
-nix run $a#hello
+nix run "$a"#hello
+`,
+		},
+		{
+			name: "declare attributes",
+			in: `declare -x A=1
+export -n B
+f() {
+  declare -i n=1+2
+  n=$n*3
+  n+=4
+  local -u up=abc
+  typeset -g G=1
+}
+declare -l low
+low=ABC
+declare -u up; declare +l up
+up=abc`,
+			expected: `set -x A '1'
+set -gu B $B
+function f
+  set -l n (math -s0 1 '+' 2)
+  set n (math -s0 "$n" '*' 3)
+  set n (math -s0 "$n" '+' 4)
+  set -l up (string upper -- 'abc')
+  set -g G '1'
+end
+set low $low
+set low (string lower -- 'ABC')
+set up $up
+set up $up
+set up (string upper -- 'abc')
+`,
+		},
+		{
+			name: "readonly",
+			in: `readonly B=2
+f() { local -r n=1; }`,
+			expected: `set -g B '2'
+set -g __babelfish_readonly_B $B
+function __babelfish_readonly_B -S --on-variable B
+  if test "$B" != "$__babelfish_readonly_B"
+    set B $__babelfish_readonly_B
+    echo 'B: readonly variable' >&2
+  end
+end
+function f
+  set -l n '1'
+  set -l __babelfish_readonly_n $n
+  function __babelfish_readonly_n -S --on-variable n
+    if set -q __babelfish_readonly_n; and test "$n" != "$__babelfish_readonly_n"
+      set n $__babelfish_readonly_n
+      echo 'n: readonly variable' >&2
+    end
+  end
+end
+`,
+		},
+		{
+			name: "nameref",
+			in: `fill() {
+  local -n out=$1
+  out=(a b)
+  out+=(c)
+  echo "${out[@]}" ${#out[@]}
+  out[2]=d
+  echo "${out[0]}"
+  unset out
+}
+arr[1]=x`,
+			expected: helperFuncs["__babelfish_echo"] + `
+function fill -S
+  set -l out $argv[1]
+  set $out a b
+  set -a $out c
+  __babelfish_echo $$out (count $$out)
+  set "$out"[3] 'd'
+  __babelfish_echo "$$out[1][1]"
+  set -e $out
+end
+set arr[2] 'x'
+`,
+		},
+		{
+			name: "trap",
+			in: `trap 'rm -f "$tmp"' EXIT INT
+trap - INT
+trap '' HUP
+trap -p EXIT
+f() {
+  local dir=$1
+  trap "rm -r $dir" RETURN
+  [ -d "$dir" ] || return 1
+}
+trap 'echo failed' ERR`,
+			expected: helperFuncs["__babelfish_return_trap"] + `
+function __babelfish_trap_EXIT --on-process-exit $fish_pid
+  rm -f "$tmp"
+end
+function __babelfish_trap_INT --on-signal INT
+  rm -f "$tmp"
+end
+functions -e __babelfish_trap_INT
+function __babelfish_trap_HUP --on-signal HUP
+end
+functions __babelfish_trap_EXIT
+function f
+  set -l dir $argv[1]
+  set -g __babelfish_trap_1 "$dir"
+  function __babelfish_trap_RETURN
+    rm -r (string split -n -- ' ' $__babelfish_trap_1 | string split -n -- \t)
+  end
+  [ -d "$dir" ] || begin; __babelfish_return_trap; return 1; end
+  __babelfish_return_trap
+end
+function __babelfish_trap_ERR --on-event fish_postexec
+  test $status -eq 0; and return
+  echo failed
+end
+`,
+		},
+		{
+			name: "set -euo pipefail",
+			in: `set -euo pipefail
+cd "$dir"
+ls "${args[@]}" ${opts[*]}
+[ -f x ] && rm x
+if false; then
+  a | b
+fi
+f() {
+  false
+}
+set +eu -x
+false`,
+			expected: helperFuncs["__babelfish_pipefail"] + `
+true
+set -q dir; or begin; echo 'dir: unbound variable' >&2; exit 1; end
+cd "$dir"; or exit $status
+ls $args (string split -n -- ' ' $opts | string split -n -- \t); or exit $status
+[ -f x ] && begin; rm x; or exit $status; end
+if false
+  begin; a | b; __babelfish_pipefail $pipestatus; end; or exit $status
+end
+function f
+  false; or return $status
+end
+set -g fish_trace 1
+false
+`,
+		},
+		{
+			name: "set -e assignment",
+			in: `set -e
+x=$(false)
+set`,
+			expected: `true
+set x (begin; false; set -g __babelfish_status $status; end | string collect; or echo); test $__babelfish_status -eq 0; or exit $__babelfish_status
+set
+`,
+		},
+		{
+			name: "positional parameters",
+			in: `shift 2
+shift "$n"
+set -- a b c
+set --
+echo $#`,
+			expected: `set -e argv[1..2]
+set argv $argv[(math "$n" + 1)..]
+set argv a b c
+set argv
+echo (count $argv)
+`,
+		},
+		{
+			name: "list concatenation",
+			in:   `echo "$@" "--$@--" ${arr[*]}`,
+			expected: helperFuncs["__babelfish_echo"] + `
+function __babelfish_concat_list -d 'Add a prefix to the first and a suffix to the last element, like "prefix$@suffix"'
+  set -l prefix $argv[1]
+  set -l suffix $argv[2]
+  set -e argv[1..2]
+  if test (count $argv) -eq 0
+    printf '%s\0' "$prefix$suffix"
+    return
+  end
+  set argv[1] "$prefix$argv[1]"
+  set argv[-1] "$argv[-1]$suffix"
+  printf '%s\0' $argv
+end
+__babelfish_echo $argv (__babelfish_concat_list '--' '--' $argv | string split0) (string split -n -- ' ' $arr | string split -n -- \t)
+`,
+		},
+		{
+			name: "word splitting",
+			in: `echo $a "$b" $(cmd) "$*"
+for f in $files; do :; done
+IFS=:
+echo $PATH "$*" $(cmd)
+unset IFS
+echo $c
+IFS="$sep"
+echo $d $(cmd)`,
+			expected: helperFuncs["__babelfish_echo"] + "\n" + helperFuncs["__babelfish_ifs_split"] + `
+__babelfish_echo (string split -n -- ' ' $a | string split -n -- \t) "$b" (cmd | string split -n -- ' ' | string split -n -- \t) (string join -- ' ' $argv | string collect; or echo)
+for f in (string split -n -- ' ' $files | string split -n -- \t)
+  :
+end
+set IFS ':'
+__babelfish_echo (string split -n -- ':' $PATH) (string join -- ':' $argv | string collect; or echo) (string split -n -- ':' (cmd | string collect; or echo))
+set -e IFS
+__babelfish_echo (string split -n -- ' ' $c | string split -n -- \t)
+set IFS "$sep"
+__babelfish_echo (__babelfish_ifs_split $d | string split0) (__babelfish_ifs_split (cmd | string collect; or echo) | string split0)
+`,
+		},
+		{
+			name:            "no word splitting",
+			in:              `echo $a $(cmd)`,
+			expected:        helperFuncs["__babelfish_echo"] + "\n" + "__babelfish_echo $a (cmd)\n",
+			noWordSplitting: true,
+		},
+		{
+			name: "brace expansion",
+			in: `mkdir -p dir{1..3}
+echo {a..e} {01..10..2} {001..10} {5..1} {a,b{c,d}}`,
+			expected: `mkdir -p dir(seq 1 3)
+echo {a,b,c,d,e} (seq -w 1 2 10) (seq -f %03g 1 10) (seq 5 -1 1) {a,b{c,d}}
+`,
+		},
+		{
+			name: "patterns",
+			in: `case $x in
+  \*) a;;
+  "?"|'*'*) b;;
+  *.c|x\ y) c;;
+esac
+[[ $x == *.c && $y != "a*"* ]] && d
+[[ $x == \* ]]`,
+			expected: `switch "$x"
+case '\\*'
+  a
+case '\\?' '\\*''*'
+  b
+case '*.c' 'x y'
+  c
+end
+string match -q -- '*.c' "$x" && not string match -q -- 'a\\*''*' "$y" && d
+test "$x" = '*'
+`,
+		},
+		{
+			name: "escape unquoted literals",
+			in:   `find . -name '*.go' -exec rm {} \; ; echo %foo a~b \$HOME a\\b \(x\) *.c \*.c x\ y \n $a[1] a#b`,
+			expected: `find . -name '*.go' -exec rm \{\} \;
+echo \%foo a\~b \$HOME a\\b \(x\) (set -l __babelfish_glob *.c; set -q __babelfish_glob[1]; or set __babelfish_glob '*.c'; string join0 -- $__babelfish_glob | string split0) \*.c x\ y n "$a"\[1] a#b
+`,
+		},
+		{
+			name: "quoting contexts",
+			in: `echo "\n\$\"" \n
+echo ` + "`echo \"\\\\n\" \\\\$x`" + `
+cat <<EOF
+\$a \" \n
+EOF
+cat <<'EOF'
+\$a
+EOF`,
+			expected: helperFuncs["__babelfish_echo"] + `
+echo '\\n$"' n
+__babelfish_echo (echo '\\n' \$x | string split -n -- ' ' | string split -n -- \t)
+cat <(echo '$a \\" \\n
+'| psub)
+cat <(echo '\\$a
+'| psub)
+`,
+		},
+		{
+			name: "ansi-c strings",
+			in: `echo $'\t' $'\x1b[0m' $'\u2713 it\'s' $'\cA\101\0ignored' $"hello $USER"
+IFS=$'\n'
+echo $a`,
+			expected: helperFuncs["__babelfish_echo"] + `
+echo \t \e'[0m' '✓ it\'s' \x01'A' 'hello '"$USER"
+set IFS \n
+__babelfish_echo (string split -n -- \n $a)
+`,
+		},
+		{
+			name:     "gettext",
+			in:       `echo $"hello"`,
+			expected: "echo (gettext -d \"$TEXTDOMAIN\" -- 'hello' | string collect; or echo)\n",
+			gettext:  true,
+		},
+		{
+			name: "output process substitution",
+			in: `tee >(gzip > out.gz) < in
+for f in a; do
+  cmd 2> >(logger)
+done`,
+			expected: `function __babelfish_mkfifo -d 'Create a FIFO for >(cmd), which is removed when the shell exits'
+  set -l fifo (mktemp -u)
+  mkfifo $fifo
+  set -ga __babelfish_fifos $fifo
+  echo $fifo
+end
+function __babelfish_fifo_cleanup --on-event fish_exit
+  rm -f $__babelfish_fifos
+end
+set -l __babelfish_fifo_1 (__babelfish_mkfifo)
+fish -c 'gzip >out.gz' <$__babelfish_fifo_1 &
+tee $__babelfish_fifo_1 <in
+for f in a
+  set -l __babelfish_fifo_2 (__babelfish_mkfifo)
+  fish -c 'logger' <$__babelfish_fifo_2 &
+  cmd 2>$__babelfish_fifo_2
+end
+`,
+		},
+		{
+			name: "exec redirections",
+			in: `exec > >(logger) 2>&1
+echo hi
+exec 3>log
+echo a >&3`,
+			expected: helperFuncs["__babelfish_mkfifo"] + `
+set -l __babelfish_fifo_1 (__babelfish_mkfifo)
+fish -c 'logger' <$__babelfish_fifo_1 &
+begin
+  echo hi
+  begin
+    echo a >&3
+  end 3>log
+end >$__babelfish_fifo_1 2>&1
+`,
+		},
+		{
+			name: "concatenation",
+			in: `echo $a$b "x$1" $(cmd)foo ${x:-def}y ${x//a/b}. "${x:+set}"z
+x=$a$b`,
+			expected: helperFuncs["__babelfish_echo"] + `
+__babelfish_echo "$a""$b" 'x'"$argv[1]" (cmd | string collect; or echo)foo (string join \n -- (test -n "$x" && echo "$x" || echo 'def') | string collect; or echo)y (string join \n -- (string replace --all 'a' 'b' "$x") | string collect; or echo). (test -n "$x" && echo 'set' || echo)z
+set x "$a""$b"
+`,
+		},
+		{
+			name: "tilde expansion",
+			in: `echo ~ ~/bin ~root/x ~+ ~-/y ~"user" "~" a~b
+PATH=~/bin:$PATH:~root/sbin:a\:~
+FOO=~ cmd`,
+			expected: `echo ~ ~/bin ~root/x "$PWD" "$dirprev[-1]"/y \~'user' '~' a\~b
+set PATH "$HOME"'/bin:'"$PATH"':'(echo ~root)'/sbin:a:~'
+FOO="$HOME" cmd
+`,
+		},
+		{
+			name: "globs",
+			in: `for f in *.conf; do cat "$f"; done
+shopt -s nullglob dotglob
+for f in *.conf; do cat "$f"; done
+rm -f dir/**/*.o
+shopt -s failglob globstar
+rm -f **/*.o`,
+			expected: `for f in (set -l __babelfish_glob *.conf; set -q __babelfish_glob[1]; or set __babelfish_glob '*.conf'; string join0 -- $__babelfish_glob | string split0)
+  cat "$f"
+end
+true
+for f in {.,}*.conf
+  cat "$f"
+end
+rm -f (set -l __babelfish_glob dir/{.,}*/{.,}*.o; string join0 -- $__babelfish_glob | string split0)
+true
+rm -f {.,}**/{.,}*.o
+`,
+		},
+		{
+			name: "special variables",
+			in: `echo "$#" "$-" "$!" "$0" "$PPID" "$RANDOM" "$SECONDS" "$EPOCHSECONDS" "$EPOCHREALTIME" "$SRANDOM" "$BASHPID"
+echo "$OSTYPE" "$MACHTYPE" "$HOSTTYPE" "$PWD" "$OLDPWD"
+false | true
+echo "$PIPESTATUS" "${PIPESTATUS[1]}" "${PIPESTATUS[-1]}" "${PIPESTATUS[@]}"
+echo "$BASH_VERSION" "${BASH_VERSINFO[0]}" "${BASH_VERSINFO[*]}"`,
+			expected: `set -q __babelfish_start; or set -g __babelfish_start (date +%s)
+function __babelfish_seconds -d 'The number of seconds since the script started, like $SECONDS'
+  math (date +%s) - $__babelfish_start
+end
+echo (count $argv) (status is-interactive; and echo himBHs; or echo hB) "$last_pid" (status filename) (ps -o ppid= -p $fish_pid | string trim) (random 0 32767) (__babelfish_seconds) (date +%s) (date +%s.%6N | string match -r '^\d+\.\d{6}$'; or echo (date +%s).000000) (random 0 4294967295) "$fish_pid"
+echo (uname -s | string lower | string replace linux linux-gnu) (uname -m)-unknown-(uname -s | string lower | string replace linux linux-gnu) (uname -m) "$PWD" "$dirprev[-1]"
+false | true
+echo "$pipestatus[1]" "$pipestatus[2]" "$pipestatus[-1]" $pipestatus
+echo '5.2.21(1)-release' '5' '5 2 21 1 release '(uname -m)-unknown-(uname -s | string lower | string replace linux linux-gnu)
+`,
+		},
+		{
+			name: "call stack",
+			in: `dir="$(dirname "${BASH_SOURCE[0]}")"
+f() {
+  echo "$FUNCNAME: $LINENO"
+}
+echo "$FUNCNAME"`,
+			expected: helperFuncs["__babelfish_echo"] + `
+set dir (dirname (status current-filename) | string collect; or echo)
+function f
+  echo (status current-function)': '3
+end
+__babelfish_echo ''
+`,
+		},
+		{
+			name:       "source path",
+			in:         `dir="$(dirname "${BASH_SOURCE[0]}")"`,
+			expected:   "set dir (dirname 'lib/x.sh' | string collect; or echo)\n",
+			sourcePath: "lib/x.sh",
+		},
+		{
+			name: "script name",
+			in: `echo "usage: $0"
+f() { echo "$0: failed"; }`,
+			expected: `echo 'usage: '(status filename)
+function f
+  echo (status current-command)': failed'
+end
+`,
+		},
+		{
+			name:       "sourced script name",
+			in:         `echo "usage: $0"`,
+			expected:   "echo 'usage: ''lib/x.sh'\n",
+			sourcePath: "lib/x.sh",
+		},
+		{
+			name: "getopts",
+			in: `while getopts ":ab:h" opt; do
+  case $opt in
+    a) all=1 ;;
+    b) bee="$OPTARG" ;;
+    h|\?) echo "usage: $opt"; exit 1 ;;
+  esac
+done
+shift $((OPTIND - 1))`,
+			expected: `argparse -s 'a' 'b=' 'h' -- $argv 2>/dev/null
+or begin
+  set -l opt '?'
+  echo 'usage: '"$opt"
+  exit 1
+end
+set -l OPTIND 1
+if set -q _flag_a
+  set all '1'
+end
+if set -q _flag_b
+  set -l OPTARG $_flag_b
+  set bee "$OPTARG"
+end
+if set -q _flag_h
+  set -l opt h
+  echo 'usage: '"$opt"
+  exit 1
+end
+set argv $argv[(math (math -s0 "$OPTIND" '-' 1) + 1)..]
+`,
+		},
+		{
+			name: "getopts with break",
+			in: `while getopts "ab" opt; do
+  case $opt in
+    a) break ;;
+  esac
+done`,
+			expected: helperFuncs["__babelfish_getopts"] + `
+while __babelfish_getopts 'ab' opt $argv
+  switch "$opt"
+  case 'a'
+    break
+  end
+end
+`,
+		},
+		{
+			name: "read",
+			in: `IFS= read -r line
+IFS=: read -r user pass uid
+IFS=, read -ra fields
+read -rsp "Password: " pw
+read -n1 key
+read -d '' -u 3 x
+read -ei "$def"`,
+			expected: `read line
+read --delimiter ':' user pass uid
+read --list --delimiter ',' fields
+read --silent --prompt-str 'Password: ' pw
+read --nchars '1' key
+read --null x <&3
+read --command "$def" REPLY
+`,
+		},
+		{
+			name: "mapfile",
+			in: `mapfile -t lines < file
+readarray -t arr < <(cmd a; cmd b)
+mapfile -t -n 10 -C cb -c 3 arr < <(ls) 2>/dev/null`,
+			expected: helperFuncs["__babelfish_mapfile"] + `
+set lines (cat <file)
+set arr (cmd a; cmd b)
+set arr (ls | __babelfish_mapfile -n 10 2>/dev/null | string split0)
+for __babelfish_i in (seq 3 3 (count $arr))
+  cb (math $__babelfish_i - 1) "$arr[$__babelfish_i]"
+end
+`,
+		},
+		{
+			name: "caller",
+			in: `f() {
+  caller 0 || echo top
+}`,
+			expected: helperFuncs["__babelfish_caller"] + `
+function f
+  __babelfish_caller 0 || echo top
+end
+`,
+		},
+		{
+			name: "printf",
+			in: `printf -v out "%s-%q\n" "$a" "$b"
+printf "%q " "$@"
+printf "%(%Y-%m-%d)T %(%s)T\n" -1 -2
+printf "%(%H)T %b\n" "$ts" "a\tb"
+printf -v x "%d" 5
+f() {
+  local a
+  printf -v a "%d" 1
+  printf -v b "%d" 2
+}`,
+			expected: helperFuncs["__babelfish_seconds"] + `
+set out (printf '%s-%s\\n' "$a" (string escape -- "$b") | string collect -N; or echo)
+printf '%s ' (string escape -- $argv)
+printf '%s %s\\n' (date '+%Y-%m-%d') (date -d @$__babelfish_start '+%s')
+printf '%s %s\\n' (date -d @"$ts" '+%H') (echo -ne -- 'a\\tb' | string collect -N; or echo)
+set x (printf '%d' 5 | string collect -N; or echo)
+function f
+  set -l a $a
+  set a (printf '%d' 1 | string collect -N; or echo)
+  set -g b (printf '%d' 2 | string collect -N; or echo)
+end
+`,
+		},
+		{
+			name: "echo",
+			in: `echo -ne 'a\tb' -n
+echo -E -e -E x
+echo -- -s
+echo "$x: done"
+echo -n "$x"
+shopt -s xpg_echo
+echo 'a\nb'
+echo -E 'a\nb'`,
+			expected: helperFuncs["__babelfish_echo"] + `
+echo -ne 'a\\tb' -n
+echo x
+echo -- -- -s
+echo "$x"': done'
+__babelfish_echo -n "$x"
+true
+echo -e 'a\\nb'
+echo 'a\\nb'
+`,
+		},
+		{
+			name: "alias",
+			in: `alias ll='ls -l' g=git
+alias ls='ls --color=auto'
+alias up='cd "$1"; ls' -p
+alias x='foo # comment'
+unalias ll
+unalias -a`,
+			expected: `function ll --wraps 'ls -l' --description 'alias ll=ls -l'
+  ls -l $argv
+end
+function g --wraps 'git' --description 'alias g=git'
+  git $argv
+end
+function ls --description 'alias ls=ls --color=auto'
+  command ls --color=auto $argv
+end
+function up --wraps 'cd "$1"; ls' --description 'alias up=cd "$1"; ls'
+  cd $argv[1]
+  ls $argv
+end
+alias
+function x --wraps 'foo # comment' --description 'alias x=foo # comment'
+  foo $argv
+end
+functions -e ll
+functions -e (alias | string split -f 2 ' ')
+`,
+		},
+		{
+			name: "eval",
+			in: `eval "$(ssh-agent -s)"
+eval 'a=1; echo "a=$a"' && echo ok
+eval export B=2
+eval
+FOO=1 eval 'a; b'`,
+			expected: `string join ' ' -- (ssh-agent -s | string collect; or echo) | /bin/babelfish | source
+begin
+  set a '1'
+  echo 'a='"$a"
+end && echo ok
+set -gx B '2'
+true
+begin
+  set -lx FOO '1'
+  a
+  b
+end
+`,
+		},
+		{
+			name:            "eval options",
+			in:              `FOO=1 eval "$(pyenv init -)"`,
+			noWordSplitting: true,
+			sourcePath:      "lib/x.sh",
+			expected: `begin
+  set -lx FOO '1'
+  string join ' ' -- (pyenv init - | string collect; or echo) | /bin/babelfish -no-word-splitting -source-path 'lib/x.sh' | source
+end
 `,
 		},
 	}
@@ -85,6 +714,9 @@ nix run $a#hello
 		t.Run(test.name, func(t *testing.T) {
 			tr := NewTranslator()
 			tr.babelFishLocation = "/bin/babelfish"
+			tr.WordSplitting(!test.noWordSplitting)
+			tr.Gettext(test.gettext)
+			tr.SourcePath(test.sourcePath)
 			p := syntax.NewParser(syntax.KeepComments(true), syntax.Variant(syntax.LangBash))
 			f, err := p.Parse(strings.NewReader(test.in), test.name)
 			if err != nil {
@@ -212,7 +844,7 @@ function chruby()
 const chrubyExpected = `set CHRUBY_VERSION '0.3.9'
 set RUBIES
 for dir in "$PREFIX"'/opt/rubies' "$HOME"'/.rubies'
-  test -d "$dir" && test -n (ls -A "$dir" | string collect; or echo) && set -a RUBIES "$dir"/*
+  test -d "$dir" && test -n (ls -A "$dir" | string collect; or echo) && set -a RUBIES (set -l __babelfish_glob "$dir"/*; set -q __babelfish_glob[1]; or set __babelfish_glob "$dir"'/*'; string join0 -- $__babelfish_glob | string split0)
 end
 set -e dir
 function chruby_reset
@@ -237,22 +869,22 @@ function chruby_reset
 end
 
 function chruby_use
-  if test ! -x $argv[1]'/bin/ruby'
-    echo 'chruby: '$argv[1]'/bin/ruby not executable' >&2
+  if test ! -x "$argv[1]"'/bin/ruby'
+    echo 'chruby: '"$argv[1]"'/bin/ruby not executable' >&2
     return 1
   end
   test -n "$RUBY_ROOT" && chruby_reset
   set -gx RUBY_ROOT $argv[1]
   set -gx RUBYOPT $argv[2]
   set -gx PATH "$RUBY_ROOT"'/bin:'"$PATH"
-  eval (RUBYGEMS_GEMDEPS='' "$RUBY_ROOT"'/bin/ruby' - <(echo 'puts "export RUBY_ENGINE=#{Object.const_defined?(:RUBY_ENGINE) ? RUBY_ENGINE : \'ruby\'};"
+  string join ' ' -- (RUBYGEMS_GEMDEPS='' "$RUBY_ROOT"'/bin/ruby' - <(echo 'puts "export RUBY_ENGINE=#{Object.const_defined?(:RUBY_ENGINE) ? RUBY_ENGINE : \'ruby\'};"
 puts "export RUBY_VERSION=#{RUBY_VERSION};"
 begin; require \'rubygems\'; puts "export GEM_ROOT=#{Gem.default_dir.inspect};"; rescue LoadError; end
-'| psub) | string collect; or echo)
-  set -gx PATH (test -n "$GEM_ROOT" && echo "$GEM_ROOT"'/bin:' || echo)"$PATH"
+'| psub) | string collect; or echo) | /bin/babelfish | source
+  set -gx PATH (string join \n -- (test -n "$GEM_ROOT" && echo "$GEM_ROOT"'/bin:' || echo) | string collect; or echo)"$PATH"
   if test (id -ru) -ne 0
     set -gx GEM_HOME "$HOME"'/.gem/'"$RUBY_ENGINE"'/'"$RUBY_VERSION"
-    set -gx GEM_PATH "$GEM_HOME"(test -n "$GEM_ROOT" && echo ':'"$GEM_ROOT" || echo)(test -n "$GEM_PATH" && echo ':'"$GEM_PATH" || echo)
+    set -gx GEM_PATH "$GEM_HOME"(string join \n -- (test -n "$GEM_ROOT" && echo ':'"$GEM_ROOT" || echo) | string collect; or echo)(string join \n -- (test -n "$GEM_PATH" && echo ':'"$GEM_PATH" || echo) | string collect; or echo)
     set -gx PATH "$GEM_HOME"'/bin:'"$PATH"
   end
   true
@@ -285,16 +917,16 @@ function chruby
       switch "$ruby"
       case $argv[1]
         set match "$dir" && break
-      case '*'$argv[1]'*'
+      case '*'"$argv[1]"'*'
         set match "$dir"
       end
     end
     if test -z "$match"
-      echo 'chruby: unknown Ruby: '$argv[1] >&2
+      echo 'chruby: unknown Ruby: '"$argv[1]" >&2
       return 1
     end
     set -e argv[1]
-    chruby_use "$match" "$argv"
+    chruby_use "$match" (string join -- ' ' $argv | string collect; or echo)
   end
 end
 `
@@ -365,7 +997,8 @@ a="$(ok)"
 (( 123 ))
 `
 
-const testExpected = `#!/usr/bin/env bash
+var testExpected = helperFuncs["__babelfish_echo"] + `
+#!/usr/bin/env bash
 # Prevent this file from being sourced by child shells.
 set -gx __NIX_DARWIN_SET_ENVIRONMENT_DONE '1'
 set A '2'
@@ -382,8 +1015,8 @@ cat <test.bash
 function cool
   cat | cat
 end
-echo (cat test.bash | cool | fish -c 'cool | cool | fish -c \'echo \\\'cool\\\' | cool\'')
-test -e /var/file.sh && /bin/babelfish < /var/file.sh | source
+__babelfish_echo (cat test.bash | cool | fish -c 'cool | cool | fish -c \'echo \\\'cool\\\' | cool\'' | string split -n -- ' ' | string split -n -- \t)
+test -e /var/file.sh && /bin/babelfish -source-path /var/file.sh < /var/file.sh | source
 if [ -z "$SSH_AUTH_SOCK" ]
   set -gx SSH_AUTH_SOCK (/bin/gpgconf --list-dirs agent-ssh-socket | string collect; or echo)
 end
@@ -394,10 +1027,10 @@ else if false
 else
   true
 end
-echo (set -q cool && echo 'a' || echo)
-echo (test -n "$cool" && echo 'a' || echo)
-echo (set -q cool && echo "$cool" || echo 'a')
-echo (test -n "$cool" && echo "$cool" || echo 'a')
+__babelfish_echo (string split -n -- ' ' (set -q cool && echo 'a' || echo) | string split -n -- \t)
+__babelfish_echo (string split -n -- ' ' (test -n "$cool" && echo 'a' || echo) | string split -n -- \t)
+__babelfish_echo (string split -n -- ' ' (set -q cool && echo "$cool" || echo 'a') | string split -n -- \t)
+__babelfish_echo (string split -n -- ' ' (test -n "$cool" && echo "$cool" || echo 'a') | string split -n -- \t)
 set -e ASPELL_CONF
 for i in a b c
   if [ -d "$i"'/lib/aspell' ]
@@ -406,7 +1039,7 @@ for i in a b c
   echo yes
 end
 for cmd in $argv
-  echo "$cmd"
+  __babelfish_echo "$cmd"
 end
 time sleep 1
 while true
@@ -417,13 +1050,13 @@ while not true
   echo 1
   echo 2
 end
-call $me
+call (string split -n -- ' ' $me | string split -n -- \t)
 echo (count $argv)
 echo (count $cool)
 echo (string length "$cool")
 set a (ok | string collect; or echo)
 set a (ok | string collect; or echo)
-/bin/babelfish < /etc/bashrc | source
+/bin/babelfish -source-path /etc/bashrc < /etc/bashrc | source
 test 123 != 0
 `
 
@@ -528,7 +1161,8 @@ command_not_found_handler () {
     return $?
 }`
 
-const nixIndexExpected = `#!/bin/sh
+var nixIndexExpected = helperFuncs["__babelfish_echo"] + `
+#!/bin/sh
 # for bash 4
 # this will be called when a command is entered
 # but not found in the user’s path + environment
@@ -537,14 +1171,14 @@ function command_not_found_handle
   # taken from http://www.linuxjournal.com/content/bash-command-not-found
   # - do not run when inside Midnight Commander or within a Pipe
   if [ -n (set -q MC_SID && echo "$MC_SID" || echo '') ] || ! [ -t 1 ]
-    echo $argv[1]': command not found' >&2
+    echo "$argv[1]"': command not found' >&2
     return 127
   end
   # nixpkgs should always be available even in NixOS
   set toplevel 'nixpkgs'
   set cmd $argv[1]
   set attrs (@out@/bin/nix-locate --minimal --no-group --type x --type s --top-level --whole-name --at-root '/bin/'"$cmd" | string collect; or echo)
-  set len (echo -n "$attrs" | grep -c '^' | string collect; or echo)
+  set len (__babelfish_echo -n "$attrs" | grep -c '^' | string collect; or echo)
   switch "$len"
   case '0'
     echo "$cmd"': command not found' >&2
@@ -563,7 +1197,7 @@ function command_not_found_handle
       cat >&2 <(echo 'The program \''"$cmd"'\' is currently not installed. It is provided by
 the package \''"$toplevel"'.'"$attrs"'\', which I will now install for you.
 '| psub)
-      nix-env -iA $toplevel.$attrs
+      nix-env -iA "$toplevel"."$attrs"
       if [ "$status" -eq 0 ]
         # TODO: handle pipes correctly if AUTO_RUN/INSTALL is possible
         $argv
@@ -574,11 +1208,11 @@ the package \''"$toplevel"'.'"$attrs"'\', which I will now install for you.
 '| psub)
       end
     else if ! [ -z (set -q NIX_AUTO_RUN && echo "$NIX_AUTO_RUN" || echo '') ]
-      nix-build --no-out-link -A $attrs '<'"$toplevel"'>'
+      nix-build --no-out-link -A (string split -n -- ' ' $attrs | string split -n -- \t) '<'"$toplevel"'>'
       if [ "$status" -eq 0 ]
         # how nix-shell handles commands is weird
         # $(echo $@) is need to handle this
-        nix-shell -p $attrs --run (echo $argv | string collect; or echo)
+        nix-shell -p (string split -n -- ' ' $attrs | string split -n -- \t) --run (__babelfish_echo $argv | string collect; or echo)
         return $status
       else
         cat >&2 <(echo 'Failed to install '"$toplevel"'.attrs.
@@ -613,3 +1247,55 @@ function command_not_found_handler
   return $status
 end
 `
+
+func TestUnresolvableNameref(t *testing.T) {
+	p := syntax.NewParser(syntax.Variant(syntax.LangBash))
+	f, err := p.Parse(strings.NewReader(`f() { local -n ref=prefix_$1; }`), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = NewTranslator().File(f)
+	equal(t, "unsupported: nameref ref target can't be resolved statically at 1:16", fmt.Sprint(err))
+}
+
+func TestWarnings(t *testing.T) {
+	p := syntax.NewParser(syntax.Variant(syntax.LangBash))
+	f, err := p.Parse(strings.NewReader("read -t 5 -d : x\nshopt -s histappend checkwinsize\nprintf '%(%F)T' \"$t\"\ncaller"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := NewTranslator()
+	if err := tr.File(f); err != nil {
+		t.Fatal(err)
+	}
+	equal(t, []string{
+		`read -t has no equivalent in fish, it waits without a timeout at 1:1`,
+		`read -d ":" has no equivalent in fish, it reads a line at 1:1`,
+		`shopt option histappend doesn't change the translation at 2:10`,
+		`shopt option checkwinsize doesn't change the translation at 2:21`,
+		`printf %(%F)T formats a given time with date -d, which only GNU date supports at 3:17`,
+		`caller reports line numbers of the fish translation, not of the bash script at 4:1`,
+	}, tr.Warnings())
+}
+
+func TestUnescape(t *testing.T) {
+	tests := []struct {
+		quote    quoteState
+		in       string
+		expected string
+	}{
+		{quoteNone, `a\ b`, `a b`},
+		{quoteNone, `\n\a\$\\`, `na$\`},
+		{quoteNone, `trailing\`, `trailing\`},
+		{quoteDouble, `\n\a`, `\n\a`},
+		{quoteDouble, `\$\"\\` + "\\`", "$\"\\`"},
+		{quoteDouble, "line\\\ncontinued", "linecontinued"},
+		{quoteDouble, `\/`, `\/`},
+		{quoteHeredoc, `\$\\` + "\\`", "$\\`"},
+		{quoteHeredoc, `\"\n`, `\"\n`},
+		{quoteRaw, `\$\\\n`, `\$\\\n`},
+	}
+	for _, test := range tests {
+		equal(t, test.expected, unescapeQuoted(test.in, test.quote))
+	}
+}